@@ -18,7 +18,7 @@ import (
 	"github.com/gathering/gondulapi"
 )
 
-// ReadPublic is used to allow GET requests without passwords, but enforce
+// ReadPublic is used to allow GET (and HEAD) requests without passwords, but enforce
 // (global) auth for all other requests. To use this, simply add
 // *auth.ReadPublic to your object struct. (the struct is empty on purpose,
 // it only exists for easy embedding)
@@ -28,7 +28,7 @@ type ReadPublic struct{}
 type Private struct{}
 
 func CheckReadPublic(basepath string, element string, method string, user string, password string) error {
-	if method == "GET" {
+	if method == "GET" || method == "HEAD" {
 		return nil
 	}
 	if user == gondulapi.Config.HTTPUser && password == gondulapi.Config.HTTPPw {
@@ -0,0 +1,23 @@
+package auth
+
+import (
+	"github.com/gathering/gondulapi"
+)
+
+// ClientCert is used to allow requests from clients with a verified TLS
+// client certificate, falling back to basic auth as Private does for
+// those without. Which certificates are verified is decided by
+// gondulapi.Config.TLSClientCAFile.
+type ClientCert struct{}
+
+func CheckClientCert(basepath string, element string, method string, credentials gondulapi.Credentials) error {
+	if credentials.Subject != "" {
+		return nil
+	}
+	return CheckPrivate(basepath, element, method, credentials.User, credentials.Password)
+}
+
+// Auth implements the gondulapi.CredentialAuther interface
+func (dummy *ClientCert) Auth(basepath string, element string, method string, credentials gondulapi.Credentials) error {
+	return CheckClientCert(basepath, element, method, credentials)
+}
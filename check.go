@@ -0,0 +1,55 @@
+/*
+Gondul GO API, readiness checks
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package gondulapi
+
+import (
+	"context"
+	"sync"
+)
+
+// Check tells if something the API depends on is ready, returning an
+// error saying why not if it isn't. The receiver runs all added checks
+// for /readyz, cancelling ctx if they take too long.
+type Check func(ctx context.Context) error
+
+var (
+	checksLock sync.Mutex
+	checks     = make(map[string]Check)
+)
+
+// AddCheck adds a readiness check, typically from the init() of the
+// package needing it. Adding a check with the same name as an existing
+// one replaces it.
+func AddCheck(name string, check Check) {
+	checksLock.Lock()
+	defer checksLock.Unlock()
+	checks[name] = check
+}
+
+// Checks returns the readiness checks added so far, by name.
+func Checks() map[string]Check {
+	checksLock.Lock()
+	defer checksLock.Unlock()
+	c := make(map[string]Check, len(checks))
+	for name, check := range checks {
+		c[name] = check
+	}
+	return c
+}
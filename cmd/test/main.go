@@ -20,6 +20,10 @@ Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
 package main
 
 import (
+	"context"
+	"os/signal"
+	"syscall"
+
 	"github.com/gathering/gondulapi"
 	"github.com/gathering/gondulapi/db"
 	_ "github.com/gathering/gondulapi/objects"
@@ -33,5 +37,9 @@ func main() {
 	if err := db.Connect(); err != nil {
 		panic(err)
 	}
-	receiver.Start()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	if err := receiver.NewServer().Start(ctx); err != nil {
+		panic(err)
+	}
 }
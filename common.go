@@ -47,17 +47,39 @@ though, such as config file management and logging. Not very exotic.
 */
 package gondulapi
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"strings"
+)
 
 // Report is an update report on write-requests. The precise meaning might
 // vary, but the gist should be the same.
+//
+// If Error is set, or Code is an error, the client gets a Problem made
+// from Error instead.
+//
+// For GET, it's not sent back, but Code, Headers, Page and Fields are
+// used. Fields, if set, lists the JSON names of the fields that were
+// actually fetched (see Query), and the receiver leaves out the rest.
 type Report struct {
-	Affected int  `json:",omitempty"`
-	Ok       int  `json:",omitempty"`
-	Failed   int  `json:",omitempty"`
-	Error    error `json:",omitempty"`
-	Code     int   `json:"-"`
-	Headers	 map[string]string `json:"-"`
+	Affected int               `json:",omitempty"`
+	Ok       int               `json:",omitempty"`
+	Failed   int               `json:",omitempty"`
+	Error    error             `json:",omitempty"`
+	Code     int               `json:"-"`
+	Headers  map[string]string `json:"-"`
+	Page     *Page             `json:"-"`
+	Fields   []string          `json:"-"`
+}
+
+// Page is filled in for paged GETs (see Query), so the receiver can link
+// to the neighbouring pages. Next and Prev are the query parameters that
+// select them, e.g. "cursor=..." or "offset=200", and are blank if there
+// is no such page.
+type Page struct {
+	Next string
+	Prev string
 }
 
 // Auther allows objects to enforce (basic) authentication optionally. For
@@ -70,18 +92,100 @@ type Auther interface {
 	Auth(basepath string, element string, method string, user string, password string) error
 }
 
+// Credentials is what the client identified itself with: basic auth, a
+// verified TLS client certificate, or both. See Config.TLSClientCAFile.
+type Credentials struct {
+	User     string
+	Password string
+	Subject  string // Subject of the verified client certificate, blank without one
+}
+
+// CredentialAuther is a variant of Auther for objects that also want to
+// know about the client certificate.
+type CredentialAuther interface {
+	Auth(basepath string, element string, method string, credentials Credentials) error
+}
+
 // Getter implements Get method, which should fetch the object represented
 // by the element path.
 type Getter interface {
 	Get(element string) (Report, error)
 }
 
+// Params holds the named parameters of a path pattern registered with
+// receiver.AddHandler, e.g. "/test/track/{track}/station/{station:int}".
+// The values are already converted to the declared type, so they are
+// either string or int.
+type Params map[string]interface{}
+
+// Request is what an object gets to know about the request it is asked to
+// act on: the element path and whatever the receiver has parsed out of
+// the URL on its behalf. It deliberately says nothing about the caller.
+//
+// Context carries the request ID, and is cancelled if the client goes
+// away or the request times out. It should be passed on to the db
+// Context-functions, so everything logged while serving the request can
+// be correlated and the queries are cancelled along with the request.
+type Request struct {
+	Element string          // The path after the registered url
+	Params  Params          // Named path parameters, if registered with a pattern
+	Query   Query           // Collection options from the query string
+	Context context.Context // The context of the HTTP request
+}
+
+// Query holds the options for collection GETs that the receiver parsed
+// from the query string. It is up to the object to pass it on, typically
+// to db.SelectQuery, which validates it against the element type.
+type Query struct {
+	Filter []Filter
+	Limit  int      // Page size, 0 for everything
+	Offset int      // Rows to skip, for offset-based paging
+	Cursor string   // Opaque keyset cursor from a previous Page
+	Total  bool     // Count the total number of matches as well
+	Sort   []string // Fields to sort by, prefixed with - for descending
+	Fields []string // Fields to fetch, empty for all
+}
+
+// Filter is a single condition from the query string, e.g.
+// ?distro_name=foo or ?last_updated>=2020-01-01. Op is one of eq (=),
+// ne (!=), like (~=), gt (>), ge (>=), lt (<) and le (<=). Since every
+// key=value in the query string is an eq Filter, those on fields that
+// can't be filtered on should be ignored rather than rejected, as
+// db.SelectQuery does.
+type Filter struct {
+	Field string
+	Op    string
+	Value string
+}
+
+// RequestGetter is a variant of Getter for objects that need more than
+// the element path, typically the Params of a path pattern.
+type RequestGetter interface {
+	Get(request *Request) (Report, error)
+}
+
+// ContextGetter is a variant of Getter for objects that only need the
+// element path and the context of the request, see Request.
+type ContextGetter interface {
+	Get(ctx context.Context, element string) (Report, error)
+}
+
 // Putter is an idempotent method that requires an absolute path. It should
 // (over-)write the object found at the element path.
 type Putter interface {
 	Put(element string) (Report, error)
 }
 
+// RequestPutter is the Request-variant of Putter.
+type RequestPutter interface {
+	Put(request *Request) (Report, error)
+}
+
+// ContextPutter is the Context-variant of Putter.
+type ContextPutter interface {
+	Put(ctx context.Context, element string) (Report, error)
+}
+
 // Poster is not necessarily idempotent, but can be. It should write the
 // object provided, potentially generating a new ID for it if one isn't
 // provided in the data structure itself.
@@ -89,6 +193,11 @@ type Poster interface {
 	Post() (Report, error)
 }
 
+// ContextPoster is the Context-variant of Poster.
+type ContextPoster interface {
+	Post(ctx context.Context) (Report, error)
+}
+
 // Deleter should delete the object identified by the element. It should be
 // idempotent, in that it should be safe to call it on already-deleted
 // items.
@@ -96,6 +205,47 @@ type Deleter interface {
 	Delete(element string) (Report, error)
 }
 
+// RequestDeleter is the Request-variant of Deleter.
+type RequestDeleter interface {
+	Delete(request *Request) (Report, error)
+}
+
+// ContextDeleter is the Context-variant of Deleter.
+type ContextDeleter interface {
+	Delete(ctx context.Context, element string) (Report, error)
+}
+
+// Patcher applies a partial update to the object at the element path.
+// The receiver does the patching itself: it fetches the current object
+// with Get, applies the JSON Merge Patch (RFC 7396) or JSON Patch (RFC
+// 6902) from the client and decodes the result onto the object before
+// calling Patch. The object then looks just like for Put, except fields
+// lists the JSON names of the fields the patch changed, including those
+// set to null. See db.Patch, which writes exactly those.
+type Patcher interface {
+	Patch(element string, fields []string) (Report, error)
+}
+
+// RequestPatcher is the Request-variant of Patcher.
+type RequestPatcher interface {
+	Patch(request *Request, fields []string) (Report, error)
+}
+
+// ContextPatcher is the Context-variant of Patcher.
+type ContextPatcher interface {
+	Patch(ctx context.Context, element string, fields []string) (Report, error)
+}
+
+// Validator is implemented by objects with rules beyond what the validate
+// tags on their fields can express, typically involving several fields
+// or depending on the method. The receiver calls Validate after decoding
+// the body of a PUT, POST or PATCH, if the tags were all satisfied.
+// Returning FieldErrors reports them the same way as failed tags, in a
+// 422. Other errors are returned as they are.
+type Validator interface {
+	Validate(method string) error
+}
+
 // Errorf is a convenience-function to provide an Error data structure,
 // which is essentially the same as fmt.Errorf(), but with an HTTP status
 // code embedded into it which can be extracted.
@@ -135,3 +285,35 @@ func (e Error) Error() string {
 
 	return fmt.Sprintf("%v", e.Message)
 }
+
+// FieldError is a field that failed validation or decoding, named by its
+// JSON path, e.g. "Ports[2].Name". Decoding errors also have the offset
+// in the body where the problem was found, and may have no field at all
+// if the body isn't valid JSON.
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+	Offset  int64  `json:"offset,omitempty"`
+}
+
+// FieldErrors lists fields that failed validation. The receiver replies
+// with a 422 Error with FieldErrors as the Message.
+type FieldErrors []FieldError
+
+// String lists the fields and what is wrong with each of them.
+func (f FieldErrors) String() string {
+	list := make([]string, 0, len(f))
+	for _, e := range f {
+		if e.Field == "" {
+			list = append(list, e.Message)
+			continue
+		}
+		list = append(list, fmt.Sprintf("%s %s", e.Field, e.Message))
+	}
+	return strings.Join(list, ", ")
+}
+
+// Error lets a Validator return FieldErrors.
+func (f FieldErrors) Error() string {
+	return f.String()
+}
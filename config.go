@@ -29,13 +29,56 @@ import (
 // Config covers global configuration, and if need be it will provide
 // mechanisms for local overrides (similar to Skogul).
 var Config struct {
-	ListenAddress    string // Defaults to :8080
-	ConnectionString string // For database connections
-	Prefix           string // URL prefix, e.g. "/api".
-	HTTPUser         string // username for HTTP basic auth
-	HTTPPw           string // password for HTTP basic auth
-	Debug            bool   // Enables trace-debugging
-	Driver		 string // SQL driver, defaults to postgres
+	ListenAddress    string      // Defaults to :8080
+	ConnectionString string      // For database connections
+	Prefix           string      // URL prefix, e.g. "/api".
+	HTTPUser         string      // username for HTTP basic auth
+	HTTPPw           string      // password for HTTP basic auth
+	Debug            bool        // Enables trace-debugging
+	Driver           string      // SQL driver, defaults to postgres
+	PageSize         int         // Default page size for collections, 0 for MaxPageSize
+	MaxPageSize      int         // Maximum page size for collections, 0 for no limit
+	MaxBodySize      int64       // Maximum size of request bodies in bytes, defaults to 10MiB
+	CompressMinSize  int         // Compress replies of at least this many bytes, defaults to 1024. -1 to disable
+	ReadTimeout      int         // Seconds to read a request, defaults to 10
+	WriteTimeout     int         // Seconds to write a reply, defaults to 30
+	IdleTimeout      int         // Seconds to keep idle connections open, defaults to 120
+	ShutdownTimeout  int         // Seconds to wait for requests on shutdown, defaults to 10
+	DrainDelay       int         // Seconds to keep serving with /readyz failing before shutting down
+	RequestTimeout   int         // Seconds a request may take before its context is cancelled, 0 for no limit
+	TLSCertFile      string      // Serve HTTPS with this certificate, reloaded when changed
+	TLSKeyFile       string      // Key for TLSCertFile
+	TLSClientCAFile  string      // Verify client certificates against these CAs (mTLS)
+	TLSRequireClient bool        // Refuse clients without a certificate, instead of leaving it to auth (needs TLSClientCAFile)
+	MetricsPath      string      // Serve Prometheus metrics here, e.g. "/metrics". Blank to disable
+	OpenAPIPath      string      // Serve an OpenAPI 3 document describing the handlers here, e.g. "/openapi.json". Blank to disable
+	DisableIndex     bool        // Don't list the handlers on the root of Prefix
+	StrictJSON       bool        // Reject JSON bodies with unknown fields, duplicate keys or trailing data, can be overridden per handler
+	CORS             CORS        // Cross-origin requests, can be overridden per handler
+	RateLimits       []RateLimit // Request rate limits, replacing those set per handler in code
+}
+
+// RateLimit limits how often each client can make requests, with a token
+// bucket per client. A bucket holds Burst requests, and is refilled with
+// Rate requests per second. Clients are told how much is left with
+// X-RateLimit headers, and get a 429 with Retry-After when it's empty.
+type RateLimit struct {
+	Path    string   // Handler path including any prefix, e.g. "/api/switches/". Blank for all handlers
+	Methods []string // Methods limited, e.g. "PUT" and "POST". Blank for all
+	Rate    float64  // Requests per second
+	Burst   int      // Requests at once, defaults to Rate rounded up
+	PerUser bool     // Count per authenticated user instead of per remote address
+}
+
+// CORS configures Cross-Origin Resource Sharing, letting web pages from
+// other origins use the API. It is disabled unless Origins is set.
+type CORS struct {
+	Origins     []string // Origins allowed, e.g. "https://gondul.tg.no", or "*" for any
+	Methods     []string // Methods allowed, defaults to those the object implements
+	Headers     []string // Request headers allowed, defaults to those the receiver uses
+	Expose      []string // Response headers exposed, defaults to those the receiver sets
+	Credentials bool     // Allow credentials, e.g. basic auth
+	MaxAge      int      // Seconds a preflight can be cached, 0 to leave it to the browser
 }
 
 // ParseConfig reads a file and parses it as JSON, assuming it will be a
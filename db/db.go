@@ -22,7 +22,9 @@ Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
 package db
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 
 	gapi "github.com/gathering/gondulapi"
 	_ "github.com/lib/pq" // for postgres support
@@ -37,11 +39,17 @@ var DB *sql.DB
 // It's provided to add standard gondulapi-logging and error-types that can
 // be exposed to users.
 func Ping() error {
+	return PingContext(context.Background())
+}
+
+// PingContext is Ping, giving up when ctx is done. It is added as the
+// "database" readiness check.
+func PingContext(ctx context.Context) error {
 	if DB == nil {
 		log.Printf("Ping() issued without a valid DB. Use Connect() first.")
 		return gapi.Error{500, "Failed to communicate with the database"}
 	}
-	err := DB.Ping()
+	err := DB.PingContext(ctx)
 	if err != nil {
 		log.Printf("Failed to ping the database: %v", err)
 		return gapi.Error{500, "Failed to communicate with the database"}
@@ -50,6 +58,42 @@ func Ping() error {
 	return nil
 }
 
+func init() {
+	gapi.AddCheck("database", PingContext)
+}
+
+// CheckTables returns a readiness check that the tables exist and can be
+// read, for objects to add for the tables they use.
+func CheckTables(tables ...string) gapi.Check {
+	return func(ctx context.Context) error {
+		if DB == nil {
+			return gapi.Errorf(500, "Not connected to the database")
+		}
+		for _, table := range tables {
+			rows, err := DB.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s LIMIT 0", table))
+			if err != nil {
+				log.Printf("Readiness check of table %s failed: %v", table, err)
+				return gapi.Errorf(500, "Table %s is missing or can't be read", table)
+			}
+			rows.Close()
+		}
+		return nil
+	}
+}
+
+// queryError is the error for a failed query: a 503 if it failed because
+// ctx is done, e.g. a request timeout, otherwise gondulapi.InternalError,
+// since the details are logged and not for the client.
+func queryError(ctx context.Context) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return gapi.Errorf(503, "Timed out waiting for the database")
+	case context.Canceled:
+		return gapi.Errorf(503, "Request cancelled while waiting for the database")
+	}
+	return gapi.InternalError
+}
+
 // Connect sets up the database connection, using the configured
 // ConnectionString, and ensures it is working.
 func Connect() error {
@@ -0,0 +1,118 @@
+/*
+Gondul GO API, fake database driver for tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	"github.com/gathering/gondulapi/db"
+)
+
+// recorder is a database/sql driver that records the queries it gets and
+// answers every SELECT with the same rows, so the queries db builds can be
+// tested without a database. Rows are keyed by column name, and only the
+// columns asked for are returned. COUNT(*) gets the number of rows.
+type recorder struct {
+	rows    []map[string]driver.Value
+	queries []string
+	args    [][]driver.Value
+}
+
+// fake makes db use a recorder answering with rows until the test is done.
+// The driver is left unset, so placeholders are ?, even if db.Connect has
+// set it.
+func fake(t *testing.T, rows ...map[string]driver.Value) *recorder {
+	r := &recorder{rows: rows}
+	old, oldDriver, fakeDB := db.DB, gondulapi.Config.Driver, sql.OpenDB(r)
+	db.DB, gondulapi.Config.Driver = fakeDB, ""
+	t.Cleanup(func() {
+		fakeDB.Close()
+		db.DB, gondulapi.Config.Driver = old, oldDriver
+	})
+	return r
+}
+
+// last returns the last query and its arguments, as one string.
+func (r *recorder) last() string {
+	if len(r.queries) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s %v", r.queries[len(r.queries)-1], r.args[len(r.args)-1])
+}
+
+func (r *recorder) Open(name string) (driver.Conn, error)            { return conn{r}, nil }
+func (r *recorder) Connect(ctx context.Context) (driver.Conn, error) { return conn{r}, nil }
+func (r *recorder) Driver() driver.Driver                            { return r }
+
+type conn struct{ r *recorder }
+
+func (c conn) Prepare(query string) (driver.Stmt, error) { return stmt{c.r, query}, nil }
+func (c conn) Close() error                              { return nil }
+func (c conn) Begin() (driver.Tx, error)                 { return nil, fmt.Errorf("no transactions") }
+
+type stmt struct {
+	r     *recorder
+	query string
+}
+
+func (s stmt) Close() error  { return nil }
+func (s stmt) NumInput() int { return -1 }
+
+func (s stmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.r.queries = append(s.r.queries, s.query)
+	s.r.args = append(s.r.args, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s stmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.r.queries = append(s.r.queries, s.query)
+	s.r.args = append(s.r.args, args)
+	if strings.HasPrefix(s.query, "SELECT COUNT(*)") {
+		return &rows{columns: []string{"count"}, data: []map[string]driver.Value{{"count": int64(len(s.r.rows))}}}, nil
+	}
+	list, _, _ := strings.Cut(strings.TrimPrefix(s.query, "SELECT "), " FROM ")
+	return &rows{columns: strings.Split(list, ","), data: s.r.rows}, nil
+}
+
+type rows struct {
+	columns []string
+	data    []map[string]driver.Value
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.data) == 0 {
+		return io.EOF
+	}
+	for idx, col := range r.columns {
+		dest[idx] = r.data[0][col]
+	}
+	r.data = r.data[1:]
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+Gondul GO API, database integration
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gathering/gondulapi/metrics"
+)
+
+var (
+	queries  = metrics.NewCounter("gondulapi_db_queries_total", "Database queries issued, by operation.", "op")
+	duration = metrics.NewHistogram("gondulapi_db_query_duration_seconds", "Time spent on database queries, by operation.", nil, "op")
+	failures = metrics.NewCounter("gondulapi_db_errors_total", "Database queries that failed, by operation.", "op")
+)
+
+// observe records a query for op, e.g. "select" or "upsert", that started
+// at start and ended with err.
+func observe(op string, start time.Time, err error) {
+	queries.Inc(op)
+	duration.Observe(time.Since(start).Seconds(), op)
+	if err != nil {
+		failures.Inc(op)
+	}
+}
+
+// stats returns a function reading a single value from DB.Stats(), which
+// is 0 without a database.
+func stats(fn func(s sql.DBStats) float64) func() float64 {
+	return func() float64 {
+		if DB == nil {
+			return 0
+		}
+		return fn(DB.Stats())
+	}
+}
+
+func init() {
+	metrics.NewGaugeFunc("gondulapi_db_max_open_connections", "Maximum number of open connections to the database.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	metrics.NewGaugeFunc("gondulapi_db_open_connections", "Established connections to the database, in use or idle.",
+		stats(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	metrics.NewGaugeFunc("gondulapi_db_in_use_connections", "Database connections currently in use.",
+		stats(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	metrics.NewGaugeFunc("gondulapi_db_idle_connections", "Idle database connections.",
+		stats(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	metrics.NewCounterFunc("gondulapi_db_wait_count_total", "Times a query had to wait for a database connection.",
+		stats(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	metrics.NewCounterFunc("gondulapi_db_wait_duration_seconds_total", "Time spent waiting for database connections.",
+		stats(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	metrics.NewCounterFunc("gondulapi_db_max_idle_closed_total", "Connections closed due to the idle connection limit.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	metrics.NewCounterFunc("gondulapi_db_max_lifetime_closed_total", "Connections closed due to the maximum lifetime.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
@@ -0,0 +1,450 @@
+/*
+Gondul GO API, database integration
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gathering/gondulapi"
+	"github.com/gathering/gondulapi/log"
+)
+
+// sqlops maps the operator names of gondulapi.Filter to SQL.
+var sqlops = map[string]string{
+	"eq":   "=",
+	"ne":   "<>",
+	"like": "LIKE",
+	"gt":   ">",
+	"ge":   ">=",
+	"lt":   "<",
+	"le":   "<=",
+}
+
+// column describes a single exported field of a struct as seen by the
+// database.
+type column struct {
+	name   string
+	field  reflect.StructField
+	filter []string // operators allowed by the filter-tag
+	cursor string   // "asc" or "desc" if tagged as the cursor column
+}
+
+// elemType digs the element type out of d, which should be a pointer to a
+// slice of structs (or struct pointers), or a pointer to a struct.
+func elemType(ctx context.Context, d interface{}) (reflect.Type, error) {
+	t := reflect.TypeOf(d)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		log.Context(ctx).Printf("Unable to find a struct in %T", d)
+		return nil, gondulapi.InternalError
+	}
+	return t, nil
+}
+
+// columns lists the columns of st, using the same rules as enumerate:
+// only exported fields, honoring the column-tag.
+func columns(st reflect.Type) []column {
+	cols := make([]column, 0)
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		if !unicode.IsUpper(rune(field.Name[0])) {
+			continue
+		}
+		col := column{name: field.Name, field: field}
+		if ncol, ok := field.Tag.Lookup("column"); ok {
+			col.name = ncol
+		}
+		if col.name == "-" {
+			continue
+		}
+		if f, ok := field.Tag.Lookup("filter"); ok && f != "" {
+			col.filter = strings.Split(f, ",")
+		}
+		col.cursor = field.Tag.Get("cursor")
+		cols = append(cols, col)
+	}
+	return cols
+}
+
+// findColumn looks up a column by name, ignoring case since unquoted
+// identifiers are case-insensitive in SQL anyway.
+func findColumn(cols []column, name string) (column, bool) {
+	for _, col := range cols {
+		if strings.EqualFold(col.name, name) {
+			return col, true
+		}
+	}
+	return column{}, false
+}
+
+// filterSearch translates filters to searcher triples for SelectMany,
+// checking each against the filter-tags of the element type of d. The
+// haystack and operator of the triples come from the struct and sqlops,
+// never from the filter itself, and the needle is passed on to the sql
+// driver, so the result is safe to use.
+//
+// A plain key=value is only a filter if the key is a column with a
+// filter-tag, and is ignored otherwise, since query strings pick up all
+// sorts of things, like cache busters (?_=123) and tracking parameters.
+// The other operators are never used for anything else, so they are
+// rejected if they aren't allowed.
+func filterSearch(ctx context.Context, d interface{}, filters []gondulapi.Filter) ([]interface{}, error) {
+	search := make([]interface{}, 0)
+	if len(filters) == 0 {
+		return search, nil
+	}
+	st, err := elemType(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	cols := columns(st)
+	for _, filter := range filters {
+		col, found := findColumn(cols, filter.Field)
+		if filter.Op == "eq" && len(col.filter) == 0 {
+			continue
+		}
+		allowed := false
+		for _, op := range col.filter {
+			if op == filter.Op {
+				allowed = true
+			}
+		}
+		if !found || !allowed {
+			return nil, gondulapi.Errorf(400, "Can't filter on %s with %s. Allowed filters: %s", filter.Field, filter.Op, allowedFilters(cols))
+		}
+		search = append(search, col.name, sqlops[filter.Op], filter.Value)
+	}
+	return search, nil
+}
+
+// allowedFilters lists the filters allowed for cols, for use in error
+// messages. E.g.: "sysname (eq, like), mgmt_vlan (eq)".
+func allowedFilters(cols []column) string {
+	allowed := make([]string, 0)
+	for _, col := range cols {
+		if len(col.filter) > 0 {
+			allowed = append(allowed, fmt.Sprintf("%s (%s)", col.name, strings.Join(col.filter, ", ")))
+		}
+	}
+	if len(allowed) == 0 {
+		return "none"
+	}
+	return strings.Join(allowed, ", ")
+}
+
+// columnNames lists the names of cols, for use in error messages.
+func columnNames(cols []column) string {
+	names := make([]string, 0, len(cols))
+	for _, col := range cols {
+		names = append(names, col.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// jsonName returns the name the field has when marshalled to JSON.
+func (col column) jsonName() string {
+	if name, _, _ := strings.Cut(col.field.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+	return col.field.Name
+}
+
+// orderBy translates sort, as in gondulapi.Query, to an ORDER BY clause.
+// Any column can be sorted on, but only columns.
+func orderBy(cols []column, sort []string) (string, error) {
+	clauses := make([]string, 0, len(sort))
+	for _, name := range sort {
+		dir := ""
+		if strings.HasPrefix(name, "-") {
+			name, dir = name[1:], " DESC"
+		}
+		col, found := findColumn(cols, name)
+		if !found {
+			return "", gondulapi.Errorf(400, "Can't sort on %s. Allowed fields: %s", name, columnNames(cols))
+		}
+		clauses = append(clauses, col.name+dir)
+	}
+	return strings.Join(clauses, ", "), nil
+}
+
+// pick looks up the fields to SELECT, returning the set of column names
+// for selectOpts and the JSON names for gondulapi.Report.
+func pick(cols []column, fields []string) (map[string]bool, []string, error) {
+	picked := make(map[string]bool)
+	names := make([]string, 0, len(fields))
+	for _, name := range fields {
+		col, found := findColumn(cols, name)
+		if !found {
+			return nil, nil, gondulapi.Errorf(400, "No such field %s. Allowed fields: %s", name, columnNames(cols))
+		}
+		picked[col.name] = true
+		names = append(names, col.jsonName())
+	}
+	return picked, names, nil
+}
+
+// only returns the subset of kvs where the key is in columns.
+func (kvs keyvals) only(columns map[string]bool) keyvals {
+	ret := keyvals{}
+	for idx := range kvs.keys {
+		if !columns[kvs.keys[idx]] {
+			continue
+		}
+		ret.keys = append(ret.keys, kvs.keys[idx])
+		ret.keyidx = append(ret.keyidx, kvs.keyidx[idx])
+		ret.values = append(ret.values, kvs.values[idx])
+		ret.newvals = append(ret.newvals, kvs.newvals[idx])
+	}
+	return ret
+}
+
+// cursor is the decoded form of a keyset cursor: the value of the cursor
+// column of the last row of a page (After), or the first (Before).
+type cursor struct {
+	After  json.RawMessage `json:"a,omitempty"`
+	Before json.RawMessage `json:"b,omitempty"`
+}
+
+// mkcursor encodes a cursor pointing after or before row number idx of
+// the slice sl, which is found in col.
+func mkcursor(sl reflect.Value, idx int, col column, before bool) (string, error) {
+	value := reflect.Indirect(sl.Index(idx)).FieldByIndex(col.field.Index)
+	b, err := json.Marshal(value.Interface())
+	if err != nil {
+		return "", err
+	}
+	c := cursor{After: b}
+	if before {
+		c = cursor{Before: b}
+	}
+	b, err = json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return "cursor=" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// parseCursor decodes a cursor made by mkcursor into a value of the same
+// type as col, returning if it points before or after that value.
+func parseCursor(str string, col column) (needle interface{}, before bool, err error) {
+	err = gondulapi.Errorf(400, "Invalid cursor")
+	b, berr := base64.RawURLEncoding.DecodeString(str)
+	if berr != nil {
+		return
+	}
+	c := cursor{}
+	if json.Unmarshal(b, &c) != nil {
+		return
+	}
+	raw := c.After
+	if c.Before != nil {
+		raw, before = c.Before, true
+	}
+	value := reflect.New(col.field.Type)
+	if raw == nil || json.Unmarshal(raw, value.Interface()) != nil {
+		return
+	}
+	return value.Elem().Interface(), before, nil
+}
+
+// count returns the number of rows in table matching search.
+func count(ctx context.Context, table string, search []Selector) (int, error) {
+	strsearch, searcharr := buildWhere(0, search)
+	q := fmt.Sprintf("SELECT COUNT(*) FROM %s", table)
+	if strsearch != "" {
+		q = fmt.Sprintf("%s WHERE %s", q, strsearch)
+	}
+	n := 0
+	start := time.Now()
+	err := DB.QueryRowContext(ctx, q, searcharr...).Scan(&n)
+	observe("select", start, err)
+	if err != nil {
+		log.Context(ctx).Printf("count query failed: %s returned %s", q, err)
+		return 0, queryError(ctx)
+	}
+	return n, nil
+}
+
+// SelectQuery is SelectMany, but also applies the query parsed by the
+// receiver, typically request.Query of a gondulapi.RequestGetter. The
+// searcher is applied in addition to the query, so the object is still
+// in charge of what can be reached.
+//
+// A field can only be filtered on if it is tagged with the operators it
+// allows, e.g. `filter:"eq,like"` or `filter:"gt,ge,lt,le"`. Anything
+// else is rejected with a 400 that lists what is allowed, except plain
+// key=value on fields without a filter-tag, which is ignored.
+//
+// If query.Limit is set, the result is paged and report.Page links to the
+// neighbouring pages. If a field is tagged with `cursor:"asc"` or
+// `cursor:"desc"`, the result is ordered by it and paged with a keyset
+// cursor, which is stable even if rows are added while paging. It should
+// be unique and never NULL, e.g. a primary key. Without a cursor column,
+// or if query.Offset is set, paging is by offset. If query.Total is set,
+// the total number of matches is returned in the X-Total-Count header.
+//
+// query.Sort orders the result by any of the columns, e.g. "-time" for
+// descending, and disables cursors in favour of offsets. query.Fields
+// limits which columns are fetched, and tells the receiver to leave the
+// rest out through report.Fields.
+func SelectQuery(d interface{}, table string, query gondulapi.Query, searcher ...interface{}) (gondulapi.Report, error) {
+	return SelectQueryContext(context.Background(), d, table, query, searcher...)
+}
+
+// SelectQueryContext is SelectQuery on behalf of the request in ctx.
+func SelectQueryContext(ctx context.Context, d interface{}, table string, query gondulapi.Query, searcher ...interface{}) (gondulapi.Report, error) {
+	filter, err := filterSearch(ctx, d, query.Filter)
+	if err != nil {
+		return gondulapi.Report{Failed: 1}, err
+	}
+	search, err := buildSearch(ctx, append(searcher, filter...)...)
+	if err != nil {
+		return gondulapi.Report{Failed: 1}, err
+	}
+	st, err := elemType(ctx, d)
+	if err != nil {
+		return gondulapi.Report{Failed: 1}, err
+	}
+	var key *column
+	cols := columns(st)
+	for idx := range cols {
+		if cols[idx].cursor != "" && len(query.Sort) == 0 {
+			key = &cols[idx]
+			break
+		}
+	}
+	if query.Cursor != "" && (key == nil || query.Offset > 0) {
+		return gondulapi.Report{Failed: 1}, gondulapi.Errorf(400, "Can't use a cursor with an offset or sort, or on a collection without a cursor column")
+	}
+
+	opts := selectOpts{offset: query.Offset}
+	opts.order, err = orderBy(cols, query.Sort)
+	if err != nil {
+		return gondulapi.Report{Failed: 1}, err
+	}
+	var fields []string
+	if len(query.Fields) > 0 {
+		opts.columns, fields, err = pick(cols, query.Fields)
+		if err != nil {
+			return gondulapi.Report{Failed: 1}, err
+		}
+		// The cursor is read from the result, so it has to be
+		// fetched even if it's left out of the reply.
+		if key != nil {
+			opts.columns[key.name] = true
+		}
+	}
+	if query.Limit > 0 {
+		// One extra to see if there is a next page
+		opts.limit = query.Limit + 1
+	}
+	before := false
+	paged := search
+	if key != nil {
+		desc := key.cursor == "desc"
+		if query.Cursor != "" {
+			var needle interface{}
+			needle, before, err = parseCursor(query.Cursor, *key)
+			if err != nil {
+				return gondulapi.Report{Failed: 1}, err
+			}
+			op := ">"
+			if desc != before {
+				op = "<"
+			}
+			paged = append(paged[:len(paged):len(paged)], Selector{key.name, op, needle})
+		}
+		// Paging backwards is done by reversing the order, and then
+		// the result.
+		opts.order = key.name
+		if desc != before {
+			opts.order += " DESC"
+		}
+	}
+
+	report, err := selectMany(ctx, d, table, paged, opts)
+	if err != nil {
+		return report, err
+	}
+	report.Fields = fields
+	if query.Total {
+		n, err := count(ctx, table, search)
+		if err != nil {
+			return report, err
+		}
+		report.Headers["X-Total-Count"] = fmt.Sprintf("%d", n)
+	}
+	if query.Limit == 0 {
+		return report, nil
+	}
+
+	sl := reflect.Indirect(reflect.ValueOf(d))
+	if sl.Kind() == reflect.Interface {
+		sl = sl.Elem()
+	}
+	more := sl.Len() > query.Limit
+	if more {
+		sl.Set(sl.Slice(0, query.Limit))
+		report.Ok = query.Limit
+	}
+	if before {
+		swap := reflect.Swapper(sl.Interface())
+		for i, j := 0, sl.Len()-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+
+	page := gondulapi.Page{}
+	if key != nil && query.Offset == 0 {
+		n := sl.Len()
+		if n > 0 && (more || before) {
+			page.Next, err = mkcursor(sl, n-1, *key, false)
+		}
+		if err == nil && n > 0 && ((more && before) || (!before && query.Cursor != "")) {
+			page.Prev, err = mkcursor(sl, 0, *key, true)
+		}
+		if err != nil {
+			log.Context(ctx).Printf("Unable to make cursor: %s", err)
+			return report, gondulapi.InternalError
+		}
+	} else {
+		if more {
+			page.Next = fmt.Sprintf("offset=%d", query.Offset+query.Limit)
+		}
+		if query.Offset > 0 {
+			prev := query.Offset - query.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			page.Prev = fmt.Sprintf("offset=%d", prev)
+		}
+	}
+	report.Page = &page
+	return report, nil
+}
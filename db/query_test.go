@@ -0,0 +1,222 @@
+/*
+Gondul GO API, db query tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package db_test
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	"github.com/gathering/gondulapi/db"
+	h "github.com/gathering/gondulapi/helper"
+)
+
+// box is a switch of sorts, with a filter-tag or two.
+type box struct {
+	Sysname string `filter:"eq,like"`
+	Vlan    int    `column:"mgmt_vlan" json:"vlan" filter:"gt,lt"`
+	Distro  string
+}
+
+// boxes are the rows the fake database has for box, by column.
+func boxes(names ...string) []map[string]driver.Value {
+	rows := make([]map[string]driver.Value, 0, len(names))
+	for idx, name := range names {
+		rows = append(rows, map[string]driver.Value{"Sysname": name, "mgmt_vlan": int64(idx + 1), "Distro": "e1"})
+	}
+	return rows
+}
+
+// code returns the status of err, or 0 if it is nil.
+func code(err error) int {
+	if err == nil {
+		return 0
+	}
+	gerr, ok := err.(gondulapi.Error)
+	if !ok {
+		return -1
+	}
+	return gerr.Code
+}
+
+func TestFilter(t *testing.T) {
+	cases := []struct {
+		filter gondulapi.Filter
+		code   int
+		query  string
+	}{
+		{gondulapi.Filter{Field: "sysname", Op: "eq", Value: "e1-1"}, 0, "SELECT Sysname,mgmt_vlan,Distro FROM boxes WHERE   Sysname = ? [e1-1]"},
+		{gondulapi.Filter{Field: "Sysname", Op: "like", Value: "e1-%"}, 0, "SELECT Sysname,mgmt_vlan,Distro FROM boxes WHERE   Sysname LIKE ? [e1-%]"},
+		{gondulapi.Filter{Field: "mgmt_vlan", Op: "gt", Value: "10"}, 0, "SELECT Sysname,mgmt_vlan,Distro FROM boxes WHERE   mgmt_vlan > ? [10]"},
+		// Plain key=value on what can't be filtered on is ignored.
+		{gondulapi.Filter{Field: "_", Op: "eq", Value: "123"}, 0, "SELECT Sysname,mgmt_vlan,Distro FROM boxes []"},
+		{gondulapi.Filter{Field: "Distro", Op: "eq", Value: "x"}, 0, "SELECT Sysname,mgmt_vlan,Distro FROM boxes []"},
+		// Anything else is a 400.
+		{gondulapi.Filter{Field: "mgmt_vlan", Op: "eq", Value: "10"}, 400, ""},
+		{gondulapi.Filter{Field: "Sysname", Op: "ne", Value: "e1-1"}, 400, ""},
+		{gondulapi.Filter{Field: "Distro", Op: "like", Value: "x"}, 400, ""},
+		{gondulapi.Filter{Field: "_", Op: "gt", Value: "1"}, 400, ""},
+	}
+	for _, c := range cases {
+		r := fake(t)
+		list := make([]box, 0)
+		_, err := db.SelectQuery(&list, "boxes", gondulapi.Query{Filter: []gondulapi.Filter{c.filter}})
+		h.CheckEqual(t, code(err), c.code)
+		h.CheckEqual(t, r.last(), c.query)
+	}
+}
+
+// entry is paged with a cursor, unlike box.
+type entry struct {
+	ID   int `cursor:"asc"`
+	Name string
+}
+
+// entries are the rows the fake database has for entry, by column.
+func entries(ids ...int) []map[string]driver.Value {
+	rows := make([]map[string]driver.Value, 0, len(ids))
+	for _, id := range ids {
+		rows = append(rows, map[string]driver.Value{"ID": int64(id), "Name": fmt.Sprintf("entry %d", id)})
+	}
+	return rows
+}
+
+func TestOffset(t *testing.T) {
+	r := fake(t, boxes("a", "b", "c")...)
+	list := make([]box, 0)
+	report, err := db.SelectQuery(&list, "boxes", gondulapi.Query{Limit: 2, Offset: 2})
+	h.CheckEqual(t, err, nil)
+	h.CheckEqual(t, r.last(), "SELECT Sysname,mgmt_vlan,Distro FROM boxes LIMIT 3 OFFSET 2 []")
+	h.CheckEqual(t, len(list), 2)
+	h.CheckEqual(t, report.Page.Next, "offset=4")
+	h.CheckEqual(t, report.Page.Prev, "offset=0")
+
+	// Without a limit, there is still a LIMIT, since MySQL needs one
+	// for OFFSET.
+	report, err = db.SelectQuery(&list, "boxes", gondulapi.Query{Offset: 5})
+	h.CheckEqual(t, err, nil)
+	h.CheckEqual(t, r.last(), "SELECT Sysname,mgmt_vlan,Distro FROM boxes LIMIT 9223372036854775807 OFFSET 5 []")
+	h.CheckEqual(t, len(list), 3)
+	h.CheckEqual(t, report.Page, (*gondulapi.Page)(nil))
+
+	_, err = db.SelectQuery(&list, "boxes", gondulapi.Query{Cursor: "eyJhIjoxfQ"})
+	h.CheckEqual(t, code(err), 400)
+}
+
+func TestCursor(t *testing.T) {
+	r := fake(t, entries(1, 2, 3)...)
+	list := make([]entry, 0)
+	report, err := db.SelectQuery(&list, "entries", gondulapi.Query{Limit: 2})
+	h.CheckEqual(t, err, nil)
+	h.CheckEqual(t, r.last(), "SELECT ID,Name FROM entries ORDER BY ID LIMIT 3 []")
+	h.CheckEqual(t, len(list), 2)
+	h.CheckEqual(t, report.Page.Prev, "")
+	h.CheckEqual(t, strings.HasPrefix(report.Page.Next, "cursor="), true)
+
+	// The next page starts after the last entry of this one.
+	next := strings.TrimPrefix(report.Page.Next, "cursor=")
+	report, err = db.SelectQuery(&list, "entries", gondulapi.Query{Limit: 2, Cursor: next})
+	h.CheckEqual(t, err, nil)
+	h.CheckEqual(t, r.last(), "SELECT ID,Name FROM entries WHERE   ID > ? ORDER BY ID LIMIT 3 [2]")
+	h.CheckEqual(t, strings.HasPrefix(report.Page.Prev, "cursor="), true)
+
+	// The previous page ends before the first entry of this one, and is
+	// fetched backwards and reversed.
+	r.rows = entries(3, 2, 1)
+	prev := strings.TrimPrefix(report.Page.Prev, "cursor=")
+	report, err = db.SelectQuery(&list, "entries", gondulapi.Query{Limit: 2, Cursor: prev})
+	h.CheckEqual(t, err, nil)
+	h.CheckEqual(t, r.last(), "SELECT ID,Name FROM entries WHERE   ID < ? ORDER BY ID DESC LIMIT 3 [1]")
+	h.CheckEqual(t, len(list), 2)
+	h.CheckEqual(t, list[0].ID, 2)
+	h.CheckEqual(t, list[1].ID, 3)
+	h.CheckNotEqual(t, report.Page.Next, "")
+	h.CheckNotEqual(t, report.Page.Prev, "")
+
+	for _, cursor := range []string{"!!", "bm9wZQ", "eyJhIjoieCJ9", "e30"} {
+		_, err = db.SelectQuery(&list, "entries", gondulapi.Query{Limit: 2, Cursor: cursor})
+		h.CheckEqual(t, code(err), 400)
+	}
+	_, err = db.SelectQuery(&list, "entries", gondulapi.Query{Limit: 2, Cursor: next, Offset: 2})
+	h.CheckEqual(t, code(err), 400)
+}
+
+func TestSort(t *testing.T) {
+	cases := []struct {
+		sort  []string
+		code  int
+		query string
+	}{
+		{[]string{"sysname"}, 0, "SELECT Sysname,mgmt_vlan,Distro FROM boxes ORDER BY Sysname []"},
+		{[]string{"-mgmt_vlan", "Sysname"}, 0, "SELECT Sysname,mgmt_vlan,Distro FROM boxes ORDER BY mgmt_vlan DESC, Sysname []"},
+		{[]string{"-distro"}, 0, "SELECT Sysname,mgmt_vlan,Distro FROM boxes ORDER BY Distro DESC []"},
+		{[]string{"nope"}, 400, ""},
+		{[]string{"-nope"}, 400, ""},
+		{[]string{"Sysname; DROP TABLE boxes"}, 400, ""},
+	}
+	for _, c := range cases {
+		r := fake(t, boxes("a")...)
+		list := make([]box, 0)
+		_, err := db.SelectQuery(&list, "boxes", gondulapi.Query{Sort: c.sort})
+		h.CheckEqual(t, code(err), c.code)
+		h.CheckEqual(t, r.last(), c.query)
+	}
+
+	// Sorting overrides the cursor, so paging is by offset.
+	r := fake(t, entries(3, 2, 1)...)
+	list := make([]entry, 0)
+	report, err := db.SelectQuery(&list, "entries", gondulapi.Query{Sort: []string{"-name"}, Limit: 2})
+	h.CheckEqual(t, err, nil)
+	h.CheckEqual(t, r.last(), "SELECT ID,Name FROM entries ORDER BY Name DESC LIMIT 3 []")
+	h.CheckEqual(t, report.Page.Next, "offset=2")
+}
+
+func TestFields(t *testing.T) {
+	cases := []struct {
+		fields []string
+		code   int
+		query  string
+		names  string
+	}{
+		{[]string{"sysname"}, 0, "SELECT Sysname FROM boxes []", "Sysname"},
+		{[]string{"mgmt_vlan", "Sysname"}, 0, "SELECT Sysname,mgmt_vlan FROM boxes []", "vlan|Sysname"},
+		{[]string{"nope"}, 400, "", ""},
+		{[]string{"Sysname", "mgmt_vlan FROM boxes; --"}, 400, "", ""},
+	}
+	for _, c := range cases {
+		r := fake(t, boxes("a")...)
+		list := make([]box, 0)
+		report, err := db.SelectQuery(&list, "boxes", gondulapi.Query{Fields: c.fields})
+		h.CheckEqual(t, code(err), c.code)
+		h.CheckEqual(t, r.last(), c.query)
+		h.CheckEqual(t, strings.Join(report.Fields, "|"), c.names)
+	}
+
+	// The cursor is fetched for the next page, even if it isn't asked for.
+	r := fake(t, entries(1, 2, 3)...)
+	list := make([]entry, 0)
+	report, err := db.SelectQuery(&list, "entries", gondulapi.Query{Fields: []string{"Name"}, Limit: 2})
+	h.CheckEqual(t, err, nil)
+	h.CheckEqual(t, r.last(), "SELECT ID,Name FROM entries ORDER BY ID LIMIT 3 []")
+	h.CheckEqual(t, strings.Join(report.Fields, "|"), "Name")
+	h.CheckEqual(t, list[1].ID, 2)
+}
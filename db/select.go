@@ -20,8 +20,11 @@ Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
 package db
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"reflect"
+	"time"
 
 	"github.com/gathering/gondulapi"
 	"github.com/gathering/gondulapi/log"
@@ -52,10 +55,15 @@ import (
 // zero-values of the relevant objects. After this, the query is executed
 // and the values are stored on the temporary values. The last pass stores
 func Select(d interface{}, table string, searcher ...interface{}) (report gondulapi.Report, err error) {
+	return SelectContext(context.Background(), d, table, searcher...)
+}
+
+// SelectContext is Select on behalf of the request in ctx.
+func SelectContext(ctx context.Context, d interface{}, table string, searcher ...interface{}) (report gondulapi.Report, err error) {
 	err = gondulapi.InternalError
 	st := reflect.ValueOf(d)
 	if st.Kind() != reflect.Ptr {
-		log.Printf("Select() called with non-pointer interface. This wouldn't really work.")
+		log.Context(ctx).Printf("Select() called with non-pointer interface. This wouldn't really work.")
 		return
 	}
 	st = reflect.Indirect(st)
@@ -65,10 +73,10 @@ func Select(d interface{}, table string, searcher ...interface{}) (report gondul
 	retvi := retv.Interface()
 
 	// Do the actual work :D
-	report, err = SelectMany(&retvi, table, searcher...)
+	report, err = SelectManyContext(ctx, &retvi, table, searcher...)
 
 	if err != nil {
-		log.Printf("Call to SelectMany() from Select() failed: %s", err)
+		log.Context(ctx).Printf("Call to SelectMany() from Select() failed: %s", err)
 		return
 	}
 	// retvi will be overwritten with the response (because that's how
@@ -129,20 +137,44 @@ func buildWhere(offset int, search []Selector) (string, []interface{}) {
 // the result. Once this loop is done, it executes the query, then iterates
 // over the replies, storing them in new base elements. At the very end,
 // the *d is overwritten with the new slice.
-func SelectMany(d interface{}, table string, searcher ...interface{}) (report gondulapi.Report, reterr error) {
+func SelectMany(d interface{}, table string, searcher ...interface{}) (gondulapi.Report, error) {
+	return SelectManyContext(context.Background(), d, table, searcher...)
+}
+
+// SelectManyContext is SelectMany on behalf of the request in ctx.
+func SelectManyContext(ctx context.Context, d interface{}, table string, searcher ...interface{}) (gondulapi.Report, error) {
+	search, err := buildSearch(ctx, searcher...)
+	if err != nil {
+		return gondulapi.Report{Failed: 1}, err
+	}
+	return selectMany(ctx, d, table, search, selectOpts{})
+}
+
+// selectOpts are the parts of a SELECT that come after the WHERE, used for
+// sorting and paging by SelectQuery.
+type selectOpts struct {
+	order   string          // ORDER BY clause, without the keywords
+	limit   int             // 0 for no LIMIT
+	offset  int             //
+	columns map[string]bool // Columns to SELECT, nil for all
+}
+
+// selectMany does the actual work of SelectMany, see its documentation.
+func selectMany(ctx context.Context, d interface{}, table string, search []Selector, opts selectOpts) (report gondulapi.Report, reterr error) {
 	reterr = gondulapi.InternalError
 	report = gondulapi.Report{}
 	report.Headers = make(map[string]string)
 	report.Headers["Cache-Control"] = "max-age=1"
+	logger := log.Context(ctx)
 	if DB == nil {
-		log.Printf("Tried to issue SelectMany() without a DB object")
+		logger.Printf("Tried to issue SelectMany() without a DB object")
 		return
 	}
 	dval := reflect.ValueOf(d)
 	// This is needed because we need to be able to update with a
 	// potentially new slice.
 	if dval.Kind() != reflect.Ptr {
-		log.Printf("SelectMany() called with non-pointer interface. This wouldn't really work. Got %T", d)
+		logger.Printf("SelectMany() called with non-pointer interface. This wouldn't really work. Got %T", d)
 		return
 	}
 	dval = reflect.Indirect(dval)
@@ -154,12 +186,7 @@ func SelectMany(d interface{}, table string, searcher ...interface{}) (report go
 	}
 	// And obviously it needs to actually be a slice.
 	if dval.Kind() != reflect.Slice {
-		log.Printf("SelectMany() must be called with pointer-to-slice, e.g: &[]foo, got: %T inner is: %v / %#v / %s / kind: %s", d, dval, dval, dval, dval.Kind())
-		return
-	}
-
-	search, reterr := buildSearch(searcher...)
-	if reterr != nil {
+		logger.Printf("SelectMany() must be called with pointer-to-slice, e.g: &[]foo, got: %T inner is: %v / %#v / %s / kind: %s", d, dval, dval, dval, dval.Kind())
 		return
 	}
 	// st stores the type we need to return an array, while fieldList
@@ -180,22 +207,49 @@ func SelectMany(d interface{}, table string, searcher ...interface{}) (report go
 	sample := reflect.New(fieldList)
 	sampleUnderscoreRaw := sample.Interface()
 	haystacks := make(map[string]bool, 0)
-	kvs, err := enumerate(haystacks, true, &sampleUnderscoreRaw)
+	kvs, err := enumerate(ctx, haystacks, true, &sampleUnderscoreRaw)
 	if err != nil {
-		log.Printf("enumerate() failed during query. This is bad. Error: %s", err)
+		logger.Printf("enumerate() failed during query. This is bad. Error: %s", err)
 		return
 	}
+	if opts.columns != nil {
+		kvs = kvs.only(opts.columns)
+	}
 	for idx := range kvs.keys {
 		keys = fmt.Sprintf("%s%s%s", keys, comma, kvs.keys[idx])
 		comma = ","
 	}
 	strsearch, searcharr := buildWhere(0, search)
-	q := fmt.Sprintf("SELECT %s FROM %s WHERE %s", keys, table, strsearch)
-	rows, err := DB.Query(q, searcharr...)
+	q := fmt.Sprintf("SELECT %s FROM %s", keys, table)
+	if strsearch != "" {
+		q = fmt.Sprintf("%s WHERE %s", q, strsearch)
+	}
+	if opts.order != "" {
+		q = fmt.Sprintf("%s ORDER BY %s", q, opts.order)
+	}
+	// MySQL has no OFFSET without LIMIT, so the limit is as high as
+	// both it and PostgreSQL allow.
+	limit := int64(opts.limit)
+	if limit == 0 && opts.offset > 0 {
+		limit = math.MaxInt64
+	}
+	if limit > 0 {
+		q = fmt.Sprintf("%s LIMIT %d", q, limit)
+	}
+	if opts.offset > 0 {
+		q = fmt.Sprintf("%s OFFSET %d", q, opts.offset)
+	}
+	start := time.Now()
+	rows, err := DB.QueryContext(ctx, q, searcharr...)
 	if err != nil {
-		log.Printf("query failed: %s returned %s", q, err)
+		observe("select", start, err)
+		logger.Printf("query failed: %s returned %s", q, err)
+		reterr = queryError(ctx)
 		return
 	}
+	defer func() {
+		observe("select", start, reterr)
+	}()
 	defer func() {
 		rows.Close()
 	}()
@@ -208,7 +262,7 @@ func SelectMany(d interface{}, table string, searcher ...interface{}) (report go
 		}
 		err = rows.Scan(kvs.newvals...)
 		if err != nil {
-			log.Printf("unable to Scan() row for query %s: %s", q, err)
+			logger.Printf("unable to Scan() row for query %s: %s", q, err)
 			return
 		}
 		report.Ok++
@@ -233,11 +287,16 @@ func SelectMany(d interface{}, table string, searcher ...interface{}) (report go
 		}
 		retv = reflect.Append(retv, newidx)
 	}
+	if err := rows.Err(); err != nil {
+		logger.Printf("reading rows failed for query %s: %s", q, err)
+		reterr = queryError(ctx)
+		return
+	}
 
 	// Finally - store the new slice to the pointer provided as input
 	setthis := reflect.Indirect(reflect.ValueOf(d))
 	setthis.Set(retv)
-	reterr =  nil
+	reterr = nil
 	return
 }
 
@@ -246,17 +305,29 @@ func SelectMany(d interface{}, table string, searcher ...interface{}) (report go
 // it doesn't find it - including if an error occurs (which will also be
 // returned).
 func Exists(table string, searcher ...interface{}) (found bool, err error) {
-	search, err := buildSearch(searcher...)
+	return ExistsContext(context.Background(), table, searcher...)
+}
+
+// ExistsContext is Exists on behalf of the request in ctx.
+func ExistsContext(ctx context.Context, table string, searcher ...interface{}) (found bool, err error) {
+	return existsAs(ctx, "exists", table, searcher...)
+}
+
+// existsAs is Exists, recording the query in the metrics as op.
+func existsAs(ctx context.Context, op string, table string, searcher ...interface{}) (found bool, err error) {
+	search, err := buildSearch(ctx, searcher...)
 	if err != nil {
-		log.Printf("Unable to build search: %s", err)
+		log.Context(ctx).Printf("Unable to build search: %s", err)
 		return false, gondulapi.InternalError
 	}
 	searchstr, searcharr := buildWhere(0, search)
 	q := fmt.Sprintf("SELECT * FROM %s WHERE %s LIMIT 1", table, searchstr)
-	rows, err := DB.Query(q, searcharr...)
+	start := time.Now()
+	rows, err := DB.QueryContext(ctx, q, searcharr...)
+	observe(op, start, err)
 	if err != nil {
-		log.Printf("unable to test for existence, query failed: %s: %s", q, err)
-		return false, gondulapi.InternalError
+		log.Context(ctx).Printf("unable to test for existence, query failed: %s: %s", q, err)
+		return false, queryError(ctx)
 	}
 	defer func() {
 		// XXX: Unsure if this is actually needed here, to be
@@ -271,7 +342,7 @@ func Exists(table string, searcher ...interface{}) (found bool, err error) {
 	return
 }
 
-func buildSearch(searcher ...interface{}) ([]Selector, error) {
+func buildSearch(ctx context.Context, searcher ...interface{}) ([]Selector, error) {
 	var search []Selector
 	if len(searcher) == 0 {
 		search = []Selector{}
@@ -280,7 +351,13 @@ func buildSearch(searcher ...interface{}) ([]Selector, error) {
 	} else {
 		search = make([]Selector, 0)
 		for i := 0; i < len(searcher); i += 3 {
-			search = append(search, Selector{searcher[i].(string), searcher[i+1].(string), searcher[i+2]})
+			haystack, hok := searcher[i].(string)
+			operator, ook := searcher[i+1].(string)
+			if !hok || !ook {
+				log.Context(ctx).Printf("Invalid search, haystack and operator must be strings, got %T and %T", searcher[i], searcher[i+1])
+				return nil, gondulapi.Errorf(500, "Invalid search function call")
+			}
+			search = append(search, Selector{haystack, operator, searcher[i+2]})
 		}
 	}
 	return search, nil
@@ -293,12 +370,17 @@ func buildSearch(searcher ...interface{}) ([]Selector, error) {
 // It is provided so callers can implement receiver.Getter by simply
 // calling this to get reasonable default-behavior.
 func Get(item interface{}, table string, searcher ...interface{}) (gondulapi.Report, error) {
-	report  := gondulapi.Report{}
-	report, err := Select(item, table, searcher...)
-	report.Headers  =  make(map[string]string)
+	return GetContext(context.Background(), item, table, searcher...)
+}
+
+// GetContext is Get on behalf of the request in ctx.
+func GetContext(ctx context.Context, item interface{}, table string, searcher ...interface{}) (gondulapi.Report, error) {
+	report := gondulapi.Report{}
+	report, err := SelectContext(ctx, item, table, searcher...)
+	report.Headers = make(map[string]string)
 	report.Headers["Cache-Control"] = "max-age=1"
 	if err != nil {
-		return report, gondulapi.InternalError
+		return report, err
 	}
 	if report.Ok == 0 {
 		return report, gondulapi.Errorf(404, "Couldn't find item ")
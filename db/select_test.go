@@ -38,37 +38,46 @@ type system struct {
 	Placement *types.Box
 }
 
+// connect connects to the test database, which needs a things table
+// with e1-3 in it, or skips the test if there is none.
+func connect(t *testing.T) {
+	t.Helper()
+	if err := db.Connect(); err != nil {
+		db.DB = nil
+		t.Skipf("No test database: %v", err)
+	}
+}
+
 func TestSelectMany(t *testing.T) {
 	systems := make([]system, 0)
-	err := db.SelectMany(1, "1", "things", &systems)
+	_, err := db.SelectMany(&systems, "things", "1", "=", 1)
 	h.CheckNotEqual(t, err, nil)
-	err = db.Connect()
-	h.CheckEqual(t, err, nil)
-	err = db.SelectMany(1, "1", "things", &systems)
+	connect(t)
+	_, err = db.SelectMany(&systems, "things", "1", "=", 1)
 	h.CheckEqual(t, err, nil)
 	h.CheckNotEqual(t, len(systems), 0)
 	t.Logf("Passed base test, got %d items back", len(systems))
 
 	indirect := make([]*system, 0)
-	err = db.SelectMany(1, "1", "things", &indirect)
+	_, err = db.SelectMany(&indirect, "things", "1", "=", 1)
 	h.CheckEqual(t, err, nil)
 	h.CheckNotEqual(t, len(indirect), 0)
 
-	err = db.SelectMany(1, "2", "things", &systems)
+	_, err = db.SelectMany(&systems, "things", "2", "=", 1)
 	h.CheckEqual(t, err, nil)
 	h.CheckEqual(t, len(systems), 0)
 
-	err = db.SelectMany(1, "1", "asfasf", &systems)
+	_, err = db.SelectMany(&systems, "asfasf", "1", "=", 1)
 	h.CheckNotEqual(t, err, nil)
 
-	err = db.SelectMany(1, "1", "things", nil)
+	_, err = db.SelectMany(nil, "things", "1", "=", 1)
 	h.CheckNotEqual(t, err, nil)
 
-	err = db.SelectMany(1, "1", "things", systems)
+	_, err = db.SelectMany(systems, "things", "1", "=", 1)
 	h.CheckNotEqual(t, err, nil)
 
 	aSystem := system{}
-	err = db.SelectMany(1, "1", "things", &aSystem)
+	_, err = db.SelectMany(&aSystem, "things", "1", "=", 1)
 	h.CheckNotEqual(t, err, nil)
 	db.DB.Close()
 	db.DB = nil
@@ -76,27 +85,26 @@ func TestSelectMany(t *testing.T) {
 
 func TestSelect(t *testing.T) {
 	item := system{}
-	found, err := db.Select(1, "1", "things", &item)
+	_, err := db.Select(&item, "things", "1", "=", 1)
 	h.CheckNotEqual(t, err, nil)
 
-	err = db.Connect()
-	h.CheckEqual(t, err, nil)
+	connect(t)
 
-	found, err = db.Select(1, "1", "things", &item)
+	report, err := db.Select(&item, "things", "1", "=", 1)
 	h.CheckEqual(t, err, nil)
-	h.CheckNotEqual(t, found, false)
+	h.CheckNotEqual(t, report.Ok, 0)
 
-	found, err = db.Select(1, "1", "things", item)
+	report, err = db.Select(item, "things", "1", "=", 1)
 	h.CheckNotEqual(t, err, nil)
-	h.CheckNotEqual(t, found, true)
+	h.CheckEqual(t, report.Ok, 0)
 
-	found, err = db.Select(1, "sysnax", "things", &item)
+	report, err = db.Select(&item, "things", "sysnax", "=", 1)
 	h.CheckNotEqual(t, err, nil)
-	h.CheckNotEqual(t, found, true)
+	h.CheckEqual(t, report.Ok, 0)
 
-	found, err = db.Select("e1-3", "sysname", "things", &item)
+	report, err = db.Select(&item, "things", "sysname", "=", "e1-3")
 	h.CheckEqual(t, err, nil)
-	h.CheckEqual(t, found, true)
+	h.CheckEqual(t, report.Ok, 1)
 	h.CheckEqual(t, item.Sysname, "e1-3")
 	h.CheckEqual(t, *item.Vlan, 1)
 	db.DB.Close()
@@ -105,38 +113,37 @@ func TestSelect(t *testing.T) {
 
 func TestUpdate(t *testing.T) {
 	item := system{}
-	err := db.Connect()
-	h.CheckEqual(t, err, nil)
+	connect(t)
 
-	found, err := db.Select("e1-3", "sysname", "things", &item)
+	report, err := db.Select(&item, "things", "sysname", "=", "e1-3")
 	h.CheckEqual(t, err, nil)
-	h.CheckEqual(t, found, true)
+	h.CheckEqual(t, report.Ok, 1)
 	h.CheckEqual(t, *item.Vlan, 1)
 
 	*item.Vlan = 42
-	report, err := db.Update("e1-3", "sysname", "things", &item)
+	report, err = db.Update(&item, "things", "sysname", "=", "e1-3")
 	h.CheckEqual(t, err, nil)
 	h.CheckEqual(t, report.Affected, 1)
 	h.CheckEqual(t, report.Ok, 1)
 	h.CheckEqual(t, report.Failed, 0)
 
 	*item.Vlan = 0
-	found, err = db.Select("e1-3", "sysname", "things", &item)
+	report, err = db.Select(&item, "things", "sysname", "=", "e1-3")
 	h.CheckEqual(t, err, nil)
-	h.CheckEqual(t, found, true)
+	h.CheckEqual(t, report.Ok, 1)
 	h.CheckEqual(t, *item.Vlan, 42)
 
 	*item.Vlan = 1
-	report, err = db.Update("e1-3", "sysname", "things", &item)
+	report, err = db.Update(&item, "things", "sysname", "=", "e1-3")
 	h.CheckEqual(t, err, nil)
 	h.CheckEqual(t, report.Affected, 1)
 	h.CheckEqual(t, report.Ok, 1)
 	h.CheckEqual(t, report.Failed, 0)
 
 	*item.Vlan = 0
-	found, err = db.Select("e1-3", "sysname", "things", &item)
+	report, err = db.Select(&item, "things", "sysname", "=", "e1-3")
 	h.CheckEqual(t, err, nil)
-	h.CheckEqual(t, found, true)
+	h.CheckEqual(t, report.Ok, 1)
 	h.CheckEqual(t, *item.Vlan, 1)
 	db.DB.Close()
 	db.DB = nil
@@ -144,12 +151,11 @@ func TestUpdate(t *testing.T) {
 
 func TestInsert(t *testing.T) {
 	item := system{}
-	err := db.Connect()
-	h.CheckEqual(t, err, nil)
+	connect(t)
 
-	found, err := db.Select("kjeks", "sysname", "things", &item)
+	report, err := db.Select(&item, "things", "sysname", "=", "kjeks")
 	h.CheckEqual(t, err, nil)
-	h.CheckEqual(t, found, false)
+	h.CheckEqual(t, report.Ok, 0)
 
 	item.Sysname = "kjeks"
 	vlan := 42
@@ -157,22 +163,22 @@ func TestInsert(t *testing.T) {
 	newip, err := types.NewIP("192.168.2.1")
 	h.CheckEqual(t, err, nil)
 	item.Ip = &newip
-	report, err := db.Insert("things", &item)
+	report, err = db.Insert(&item, "things")
 	h.CheckEqual(t, err, nil)
 	h.CheckEqual(t, report.Affected, 1)
 	h.CheckEqual(t, report.Ok, 1)
 
-	found, err = db.Select("kjeks", "sysname", "things", &item)
+	report, err = db.Select(&item, "things", "sysname", "=", "kjeks")
 	h.CheckEqual(t, err, nil)
-	h.CheckEqual(t, found, true)
+	h.CheckEqual(t, report.Ok, 1)
 
-	report, err = db.Delete("kjeks", "sysname", "things")
+	report, err = db.Delete("things", "sysname", "=", "kjeks")
 	h.CheckEqual(t, err, nil)
 	h.CheckEqual(t, report.Affected, 1)
 	h.CheckEqual(t, report.Ok, 1)
 	h.CheckEqual(t, report.Failed, 0)
 
-	report, err = db.Upsert("kjeks", "sysname", "things", &item)
+	report, err = db.Upsert(&item, "things", "sysname", "=", "kjeks")
 	h.CheckEqual(t, err, nil)
 	h.CheckEqual(t, *item.Vlan, 42)
 	h.CheckEqual(t, report.Affected, 1)
@@ -180,19 +186,19 @@ func TestInsert(t *testing.T) {
 	h.CheckEqual(t, report.Failed, 0)
 
 	*item.Vlan = 8128
-	report, err = db.Upsert("kjeks", "sysname", "things", &item)
+	report, err = db.Upsert(&item, "things", "sysname", "=", "kjeks")
 	h.CheckEqual(t, err, nil)
 	h.CheckEqual(t, report.Affected, 1)
 	h.CheckEqual(t, report.Ok, 1)
 	h.CheckEqual(t, report.Failed, 0)
 
 	systems := make([]system, 0)
-	err = db.SelectMany("kjeks", "sysname", "things", &systems)
+	_, err = db.SelectMany(&systems, "things", "sysname", "=", "kjeks")
 	h.CheckEqual(t, err, nil)
 	h.CheckEqual(t, len(systems), 1)
 	h.CheckEqual(t, *systems[0].Vlan, 8128)
 
-	report, err = db.Delete("kjeks", "sysname", "things")
+	report, err = db.Delete("things", "sysname", "=", "kjeks")
 	h.CheckEqual(t, err, nil)
 	h.CheckEqual(t, report.Affected, 1)
 	h.CheckEqual(t, report.Ok, 1)
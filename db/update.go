@@ -44,11 +44,19 @@ Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
 // `column:"alternatename"`. If you wish to have this package ignore the
 // field entirely (e.g.: it's exported, but doesn't exist at all in the
 // database), tag it with `column:"-"`.
+//
+// All of the functions have a Context variant, e.g. SelectContext, for
+// use while serving a request. Everything they log is tagged with the
+// request ID carried by the context, see log.Context, and the queries are
+// cancelled if the context is, failing with a 503.
 package db
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"strings"
+	"time"
 	"unicode"
 
 	"github.com/gathering/gondulapi"
@@ -62,7 +70,7 @@ type keyvals struct {
 	newvals []interface{}
 }
 
-func enumerate(haystacks map[string]bool, populate bool, d interface{}) (keyvals, error) {
+func enumerate(ctx context.Context, haystacks map[string]bool, populate bool, d interface{}) (keyvals, error) {
 	v := reflect.ValueOf(d)
 	v = reflect.Indirect(v)
 	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
@@ -73,7 +81,7 @@ func enumerate(haystacks map[string]bool, populate bool, d interface{}) (keyvals
 	st := v.Type()
 	kvs := keyvals{}
 	if st.Kind() != reflect.Struct {
-		log.Printf("Got the wrong data type. Got %s / %T.", st.Kind(), d)
+		log.Context(ctx).Printf("Got the wrong data type. Got %s / %T.", st.Kind(), d)
 		return kvs, gondulapi.InternalError
 	}
 
@@ -114,8 +122,18 @@ func enumerate(haystacks map[string]bool, populate bool, d interface{}) (keyvals
 // string and matching the haystack with the needle. It skips fields that
 // are nil-pointers.
 func Update(d interface{}, table string, searcher ...interface{}) (gondulapi.Report, error) {
+	return UpdateContext(context.Background(), d, table, searcher...)
+}
+
+// UpdateContext is Update on behalf of the request in ctx.
+func UpdateContext(ctx context.Context, d interface{}, table string, searcher ...interface{}) (gondulapi.Report, error) {
+	return updateAs(ctx, "update", d, table, searcher...)
+}
+
+// updateAs is Update, recording the query in the metrics as op.
+func updateAs(ctx context.Context, op string, d interface{}, table string, searcher ...interface{}) (gondulapi.Report, error) {
 	report := gondulapi.Report{}
-	search, err := buildSearch(searcher...)
+	search, err := buildSearch(ctx, searcher...)
 	if err != nil {
 		report.Failed++
 		return report, err
@@ -124,15 +142,75 @@ func Update(d interface{}, table string, searcher ...interface{}) (gondulapi.Rep
 	for _, item := range search {
 		haystacks[item.Haystack] = true
 	}
-	kvs, err := enumerate(haystacks, false, d)
+	kvs, err := enumerate(ctx, haystacks, false, d)
 	if err != nil {
-		log.Printf("enumerate() failed: %s", err)
+		log.Context(ctx).Printf("enumerate() failed: %s", err)
 		report.Failed++
 		return report, gondulapi.InternalError
 	}
+	return update(ctx, op, table, kvs, search)
+}
+
+// Patch is Update for partial updates, typically from a gondulapi.Patcher.
+// Only the fields listed are written, but unlike Update, nil-pointers are
+// written as NULL. The fields are named as in JSON, since that is what
+// the patch was applied to, and fields that aren't columns are ignored.
+// The columns searched for identify the row, so patching them is a 422.
+func Patch(d interface{}, table string, fields []string, searcher ...interface{}) (gondulapi.Report, error) {
+	return PatchContext(context.Background(), d, table, fields, searcher...)
+}
+
+// PatchContext is Patch on behalf of the request in ctx.
+func PatchContext(ctx context.Context, d interface{}, table string, fields []string, searcher ...interface{}) (gondulapi.Report, error) {
+	report := gondulapi.Report{}
+	search, err := buildSearch(ctx, searcher...)
+	if err != nil {
+		report.Failed++
+		return report, err
+	}
+	st, err := elemType(ctx, d)
+	if err != nil {
+		report.Failed++
+		return report, err
+	}
+	v := reflect.ValueOf(d)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	kvs := keyvals{}
+	cols := columns(st)
+	for _, field := range fields {
+		for _, col := range cols {
+			if col.jsonName() != field {
+				continue
+			}
+			for _, item := range search {
+				if strings.EqualFold(item.Haystack, col.name) {
+					report.Failed++
+					return report, gondulapi.Errorf(422, "Can't change %s, since it identifies the item", field)
+				}
+			}
+			value := v.FieldByIndex(col.field.Index)
+			kvs.keys = append(kvs.keys, col.name)
+			if value.Kind() == reflect.Ptr && value.IsNil() {
+				kvs.values = append(kvs.values, nil)
+			} else {
+				kvs.values = append(kvs.values, reflect.Indirect(value).Interface())
+			}
+		}
+	}
+	return update(ctx, "update", table, kvs, search)
+}
+
+// update does the actual UPDATE for Update and Patch, setting kvs.keys
+// to kvs.values where search matches. The query is recorded as op.
+func update(ctx context.Context, op string, table string, kvs keyvals, search []Selector) (gondulapi.Report, error) {
+	report := gondulapi.Report{}
+	if len(kvs.keys) == 0 {
+		return report, nil
+	}
 	lead := fmt.Sprintf("UPDATE %s SET ", table)
 	comma := ""
-	last := 0
 	driver := gondulapi.Config.Driver
 	for idx := range kvs.keys {
 		if driver == "postgres" {
@@ -141,18 +219,19 @@ func Update(d interface{}, table string, searcher ...interface{}) (gondulapi.Rep
 			lead = fmt.Sprintf("%s%s%s = ?", lead, comma, kvs.keys[idx])
 		}
 		comma = ", "
-		last = idx
 	}
-	strsearch, searcharr := buildWhere(last+1, search)
+	strsearch, searcharr := buildWhere(len(kvs.keys), search)
 	lead = fmt.Sprintf("%s WHERE %s", lead, strsearch)
 	for _, item := range searcharr {
 		kvs.values = append(kvs.values, item)
 	}
-	res, err := DB.Exec(lead, kvs.values...)
+	start := time.Now()
+	res, err := DB.ExecContext(ctx, lead, kvs.values...)
+	observe(op, start, err)
 	if err != nil {
-		log.Printf("Failed to execute query %s: %s", lead, err)
+		log.Context(ctx).Printf("Failed to execute query %s: %s", lead, err)
 		report.Failed++
-		return report, gondulapi.InternalError
+		return report, queryError(ctx)
 	}
 	rowsaf, _ := res.RowsAffected()
 	report.Ok++
@@ -167,11 +246,21 @@ func Update(d interface{}, table string, searcher ...interface{}) (gondulapi.Rep
 // your database schema should prevent that, and calling code should
 // check if that is not the desired behavior.
 func Insert(d interface{}, table string) (gondulapi.Report, error) {
+	return InsertContext(context.Background(), d, table)
+}
+
+// InsertContext is Insert on behalf of the request in ctx.
+func InsertContext(ctx context.Context, d interface{}, table string) (gondulapi.Report, error) {
+	return insertAs(ctx, "insert", d, table)
+}
+
+// insertAs is Insert, recording the query in the metrics as op.
+func insertAs(ctx context.Context, op string, d interface{}, table string) (gondulapi.Report, error) {
 	report := gondulapi.Report{}
 	haystacks := make(map[string]bool, 0)
-	kvs, err := enumerate(haystacks, false, d)
+	kvs, err := enumerate(ctx, haystacks, false, d)
 	if err != nil {
-		log.Printf("enumerate failed: %s", err)
+		log.Context(ctx).Printf("enumerate failed: %s", err)
 		report.Failed++
 		return report, gondulapi.InternalError
 	}
@@ -189,10 +278,12 @@ func Insert(d interface{}, table string) (gondulapi.Report, error) {
 		comma = ", "
 	}
 	lead = fmt.Sprintf("%s) VALUES(%s)", lead, middle)
-	res, err := DB.Exec(lead, kvs.values...)
+	start := time.Now()
+	res, err := DB.ExecContext(ctx, lead, kvs.values...)
+	observe(op, start, err)
 	if err != nil {
-		log.Printf("failed to execute query %s: %s", lead, err)
-		return report, gondulapi.InternalError
+		log.Context(ctx).Printf("failed to execute query %s: %s", lead, err)
+		return report, queryError(ctx)
 	}
 	rowsaf, _ := res.RowsAffected()
 	report.Ok++
@@ -214,31 +305,43 @@ func Insert(d interface{}, table string) (gondulapi.Report, error) {
 // handled by a front-end doing a double-check, or by just assuming it
 // doesn't happen often enough to be worth fixing.
 func Upsert(d interface{}, table string, searcher ...interface{}) (gondulapi.Report, error) {
-	found, err := Exists(table, searcher...)
+	return UpsertContext(context.Background(), d, table, searcher...)
+}
+
+// UpsertContext is Upsert on behalf of the request in ctx.
+func UpsertContext(ctx context.Context, d interface{}, table string, searcher ...interface{}) (gondulapi.Report, error) {
+	found, err := existsAs(ctx, "upsert", table, searcher...)
 	if err != nil {
-		return gondulapi.Report{Failed: 1}, gondulapi.InternalError
+		return gondulapi.Report{Failed: 1}, err
 	}
 	if found {
-		return Update(d, table, searcher...)
+		return updateAs(ctx, "upsert", d, table, searcher...)
 	}
-	return Insert(d, table)
+	return insertAs(ctx, "upsert", d, table)
 }
 
 // Delete will delete the element, and will also delete duplicates.
 func Delete(table string, searcher ...interface{}) (gondulapi.Report, error) {
+	return DeleteContext(context.Background(), table, searcher...)
+}
+
+// DeleteContext is Delete on behalf of the request in ctx.
+func DeleteContext(ctx context.Context, table string, searcher ...interface{}) (gondulapi.Report, error) {
 	report := gondulapi.Report{}
-	search, err := buildSearch(searcher...)
+	search, err := buildSearch(ctx, searcher...)
 	if err != nil {
 		report.Failed++
 		return report, err
 	}
 	strsearch, searcharr := buildWhere(0, search)
 	q := fmt.Sprintf("DELETE FROM %s WHERE %s", table, strsearch)
-	res, err := DB.Exec(q, searcharr...)
+	start := time.Now()
+	res, err := DB.ExecContext(ctx, q, searcharr...)
+	observe("delete", start, err)
 	if err != nil {
 		report.Failed++
-		log.Printf("Unable to execute query %s: %s", q, err)
-		return report, gondulapi.InternalError
+		log.Context(ctx).Printf("Unable to execute query %s: %s", q, err)
+		return report, queryError(ctx)
 	}
 	rowsaf, _ := res.RowsAffected()
 	report.Ok++
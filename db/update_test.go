@@ -0,0 +1,56 @@
+/*
+Gondul GO API, db update tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package db_test
+
+import (
+	"testing"
+
+	"github.com/gathering/gondulapi/db"
+	h "github.com/gathering/gondulapi/helper"
+)
+
+func TestPatch(t *testing.T) {
+	cases := []struct {
+		fields []string
+		code   int
+		query  string
+	}{
+		{[]string{"vlan"}, 0, "UPDATE boxes SET mgmt_vlan = ? WHERE   sysname = ? [5 e1]"},
+		{[]string{"vlan", "Distro", "nope"}, 0, "UPDATE boxes SET mgmt_vlan = ?, Distro = ? WHERE   sysname = ? [5 debian e1]"},
+		{[]string{}, 0, ""},
+		// What identifies the row can't be patched, in any case.
+		{[]string{"Sysname"}, 422, ""},
+		{[]string{"vlan", "Sysname"}, 422, ""},
+	}
+	for _, c := range cases {
+		r := fake(t)
+		item := box{Sysname: "e2", Vlan: 5, Distro: "debian"}
+		_, err := db.Patch(&item, "boxes", c.fields, "sysname", "=", "e1")
+		h.CheckEqual(t, code(err), c.code)
+		h.CheckEqual(t, r.last(), c.query)
+	}
+
+	// Nor can a renamed column.
+	r := fake(t)
+	item := box{Sysname: "e1", Vlan: 5}
+	_, err := db.Patch(&item, "boxes", []string{"vlan"}, "mgmt_vlan", "=", 10)
+	h.CheckEqual(t, code(err), 422)
+	h.CheckEqual(t, r.last(), "")
+}
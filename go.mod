@@ -1,8 +1,15 @@
 module github.com/gathering/gondulapi
 
-go 1.20
+go 1.22
 
 require (
 	github.com/go-sql-driver/mysql v1.7.1
+	github.com/klauspost/compress v1.18.0
 	github.com/lib/pq v1.3.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 )
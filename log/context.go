@@ -0,0 +1,82 @@
+/*
+ * svipul^Wgondulapi log-wrappers
+ *
+ * Copyright (c) 2022-2024 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID, which is
+// then included in everything logged through Context(ctx).
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or the empty string.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Logger logs on behalf of a single request, prefixing every entry with
+// its ID so concurrent requests can be told apart.
+type Logger struct {
+	prefix string
+}
+
+// Context returns the Logger for the request ctx belongs to. Without a
+// request ID it logs just like the package-level functions.
+func Context(ctx context.Context) Logger {
+	if id := RequestID(ctx); id != "" {
+		return Logger{prefix: "[" + id + "] "}
+	}
+	return Logger{}
+}
+
+func (l Logger) Print(v ...any) {
+	log.Output(2, l.prefix+fmt.Sprint(v...))
+}
+
+func (l Logger) Printf(format string, v ...any) {
+	log.Output(2, l.prefix+fmt.Sprintf(format, v...))
+}
+
+func (l Logger) Debugf(format string, v ...any) {
+	if Verbose {
+		log.Output(2, l.prefix+fmt.Sprintf(format, v...))
+	}
+}
+
+func (l Logger) Tracef(format string, v ...any) {
+	if Verbose {
+		log.Output(2, l.prefix+fmt.Sprintf(format, v...))
+	}
+}
@@ -0,0 +1,311 @@
+/*
+Gondul GO API, metrics
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+// Package metrics keeps track of counters and histograms for the rest of
+// gondulapi, and exposes them in the Prometheus text format. It is
+// deliberately small: just what the receiver and db need, without pulling
+// in the Prometheus client library.
+//
+// Metrics are registered globally when created, and are typically
+// package-level variables:
+//
+//	var requests = metrics.NewCounter("gondulapi_http_requests_total", "HTTP requests handled.", "path", "method", "code")
+//	...
+//	requests.Inc("/api/switches/", "GET", "200")
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gathering/gondulapi/log"
+)
+
+// metric is anything that can write itself in the text format.
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	mu       sync.Mutex
+	registry = make(map[string]metric)
+)
+
+// register adds m to the registry. Registering the same name twice is a
+// programming error, and panics.
+func register(m metric) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := registry[m.name()]; ok {
+		panic(fmt.Sprintf("metrics: %s registered twice", m.name()))
+	}
+	registry[m.name()] = m
+}
+
+// family holds what is common for all kinds of metrics: the name, the
+// help text and the label names.
+type family struct {
+	mname  string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (f family) name() string {
+	return f.mname
+}
+
+// header writes the HELP and TYPE lines.
+func (f family) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.mname, strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.mname, f.kind)
+}
+
+// key joins label values into a map key. The values are checked against
+// the label names, since a mismatch is a programming error.
+func (f family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d labels, got %d", f.mname, len(f.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+var escaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+// labelString formats the labels for key, with extra appended, e.g.
+// {path="/api/",le="0.5"}.
+func (f family) labelString(key string, extra ...string) string {
+	pairs := make([]string, 0, len(f.labels)+1)
+	if len(f.labels) > 0 {
+		for idx, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", f.labels[idx], escaper.Replace(value)))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extra[i], escaper.Replace(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// number formats v the way Prometheus expects.
+func number(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of m, sorted.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter is a value that only goes up, split by labels.
+type Counter struct {
+	family
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter creates and registers a counter with the given label names.
+func NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{family: family{name, help, "counter", labels}, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the counter with the given label values.
+func (c *Counter) Add(v float64, values ...string) {
+	key := c.key(values)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+// Value returns the current value for the given label values.
+func (c *Counter) Value(values ...string) float64 {
+	key := c.key(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.mname, c.labelString(key), number(c.values[key]))
+	}
+}
+
+// DefaultBuckets are the upper bounds of the histogram buckets used if
+// none are given, in seconds. They are the same as Prometheus uses.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations, e.g. durations, in buckets, split by
+// labels.
+type Histogram struct {
+	family
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*observations
+}
+
+// observations are the buckets, sum and count for a set of label values.
+type observations struct {
+	counts []uint64 // Not cumulative, the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates and registers a histogram with the given buckets,
+// or DefaultBuckets if nil, and label names.
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{family: family{name, help, "histogram", labels}, buckets: buckets, values: make(map[string]*observations)}
+	register(h)
+	return h
+}
+
+// Observe records v for the given label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := h.key(values)
+	idx := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	o, ok := h.values[key]
+	if !ok {
+		o = &observations{counts: make([]uint64, len(h.buckets)+1)}
+		h.values[key] = o
+	}
+	o.counts[idx]++
+	o.sum += v
+	o.count++
+}
+
+// Count returns the number of observations for the given label values.
+func (h *Histogram) Count(values ...string) uint64 {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if o, ok := h.values[key]; ok {
+		return o.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, key := range sortedKeys(h.values) {
+		o := h.values[key]
+		cumulative := uint64(0)
+		for idx := range o.counts {
+			bound := math.Inf(1)
+			if idx < len(h.buckets) {
+				bound = h.buckets[idx]
+			}
+			cumulative += o.counts[idx]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.mname, h.labelString(key, "le", number(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.mname, h.labelString(key), number(o.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.mname, h.labelString(key), o.count)
+	}
+}
+
+// funcMetric is a gauge or counter without labels, read from a function
+// when written, e.g. from sql.DBStats.
+type funcMetric struct {
+	family
+	fn func() float64
+}
+
+// NewGaugeFunc registers a gauge that is read from fn.
+func NewGaugeFunc(name string, help string, fn func() float64) {
+	register(&funcMetric{family{name, help, "gauge", nil}, fn})
+}
+
+// NewCounterFunc registers a counter that is read from fn, for counters
+// kept elsewhere.
+func NewCounterFunc(name string, help string, fn func() float64) {
+	register(&funcMetric{family{name, help, "counter", nil}, fn})
+}
+
+func (f *funcMetric) write(w io.Writer) {
+	f.header(w)
+	fmt.Fprintf(w, "%s %s\n", f.mname, number(f.fn()))
+}
+
+// Write writes all registered metrics to w in the Prometheus text format,
+// sorted by name.
+func Write(w io.Writer) error {
+	mu.Lock()
+	metrics := make([]metric, 0, len(registry))
+	for _, name := range sortedKeys(registry) {
+		metrics = append(metrics, registry[name])
+	}
+	mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the metrics to Prometheus.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := Write(w); err != nil {
+			log.Printf("Writing metrics failed: %v", err)
+		}
+	})
+}
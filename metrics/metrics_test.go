@@ -0,0 +1,70 @@
+/*
+Gondul GO API, metrics tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package metrics_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/metrics"
+)
+
+func TestExposition(t *testing.T) {
+	c := metrics.NewCounter("test_requests_total", "Requests.\nSecond line.", "path", "code")
+	c.Inc("/a", "200")
+	c.Inc("/a", "200")
+	c.Add(0.5, "/b\"\\", "500")
+	h.CheckEqual(t, c.Value("/a", "200"), 2.0)
+
+	hist := metrics.NewHistogram("test_duration_seconds", "Durations.", []float64{1, 0.1}, "op")
+	hist.Observe(0.05, "select")
+	hist.Observe(0.1, "select")
+	hist.Observe(3, "select")
+	h.CheckEqual(t, hist.Count("select"), uint64(3))
+
+	metrics.NewGaugeFunc("test_connections", "Connections.", func() float64 { return 7 })
+
+	var buf bytes.Buffer
+	err := metrics.Write(&buf)
+	h.CheckEqual(t, err, nil)
+	h.CheckEqual(t, buf.String(), `# HELP test_connections Connections.
+# TYPE test_connections gauge
+test_connections 7
+# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{op="select",le="0.1"} 2
+test_duration_seconds_bucket{op="select",le="1"} 2
+test_duration_seconds_bucket{op="select",le="+Inf"} 3
+test_duration_seconds_sum{op="select"} 3.15
+test_duration_seconds_count{op="select"} 3
+# HELP test_requests_total Requests.\nSecond line.
+# TYPE test_requests_total counter
+test_requests_total{path="/a",code="200"} 2
+test_requests_total{path="/b\"\\",code="500"} 0.5
+`)
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	h.CheckEqual(t, w.Code, 200)
+	h.CheckEqual(t, w.Header().Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8")
+	h.CheckEqual(t, w.Body.String(), buf.String())
+}
@@ -28,15 +28,13 @@ package objects
  */
 
 import (
-	"fmt"
-	"strings"
+	"context"
 	"time"
 
 	"github.com/gathering/gondulapi"
 	"github.com/gathering/gondulapi/auth"
 	"github.com/gathering/gondulapi/db"
 	"github.com/gathering/gondulapi/receiver"
-	"github.com/gathering/gondulapi/log"
 )
 
 // Test is a single test(result), with all relevant descriptions. It is
@@ -50,8 +48,8 @@ type Test struct {
 	Description      *string       // Longer description for the test
 	Status           *string       // Actual status-result. Should probably be OK / WARN/ FAIL or something (to be defined)
 	Participant      *string       // Participant ID... somewhat legacy. Might be removed.
-	Seq		 *int	       // Sorting ID
-	*auth.ReadPublic `column:"-"`              // Authentication enforced for writes, not reads.
+	Seq              *int          // Sorting ID
+	*auth.ReadPublic `column:"-"`  // Authentication enforced for writes, not reads.
 	id               []interface{} // see mkid
 }
 
@@ -61,116 +59,138 @@ type Test struct {
 // station.
 type StationTests []Test
 
+// TestPost is Test for POST to /test/, where test results have always
+// been posted. The pattern of Test starts at /test/track/, so POSTs to
+// /test/ need a handler of their own, and one that doesn't answer GET,
+// PUT or DELETE there, since those need the identifiers from the URL.
+type TestPost Test
+
 type Docstub struct {
-	Family    *string
-	Shortname *string
-	Name      *string
-	Sequence  *int
-	Content   *string
+	Family           *string
+	Shortname        *string
+	Name             *string
+	Sequence         *int
+	Content          *string
 	*auth.ReadPublic `column:"-"`
 }
 
 type Docs []Docstub
 
 func init() {
-	receiver.AddHandler("/test/", func() interface{} { return &Test{} })
-	receiver.AddHandler("/tests/track/", func() interface{} { return &StationTests{} })
-	receiver.AddHandler("/doc/family/", func() interface{} { return &Docstub{} })
+	receiver.AddHandler("/test/track/{track}/station/{station:int}/hash/{hash}", func() interface{} { return &Test{} })
+	receiver.AddHandler("/test/", func() interface{} { return &TestPost{} })
+	receiver.AddHandler("/tests/track/{track}/station/{station:int}", func() interface{} { return &StationTests{} })
+	receiver.AddHandler("/doc/family/{family}/shortname/{shortname}", func() interface{} { return &Docstub{} })
 	receiver.AddHandler("/doc/", func() interface{} { return &Docs{} })
+	gondulapi.AddCheck("tests", db.CheckTables("results", "docs"))
 }
 
-func (ds *Docstub) Get(element string) (gondulapi.Report, error) {
-	family, shortname := "", ""
-	element = strings.Replace(element, "/", " ", -1)
-	_, err := fmt.Sscanf(element, "%s shortname %s", &family, &shortname)
-	if err != nil {
+func (ds *Docstub) Get(request *gondulapi.Request) (gondulapi.Report, error) {
+	return db.GetContext(request.Context, ds, "docs", "family", "=", request.Params["family"], "shortname", "=", request.Params["shortname"])
+}
 
-		return gondulapi.Report{Failed:1}, gondulapi.Errorf(400, "Invalid search string, need family/%%s/shortname/%%s, got family/%s, err: %v", element, err)
-	}
-	return db.Get(ds, "docs", "family", "=", family, "shortname", "=", shortname)
+func (ds Docstub) Put(request *gondulapi.Request) (gondulapi.Report, error) {
+	return db.UpsertContext(request.Context, ds, "docs", "family", "=", request.Params["family"], "shortname", "=", request.Params["shortname"])
 }
 
-func (ds Docstub) Put(element string) (gondulapi.Report, error) {
-	family, shortname := "", ""
-	element = strings.Replace(element, "/", " ", -1)
-	_, err := fmt.Sscanf(element, "%s shortname %s", &family, &shortname)
-	if err != nil {
-		return gondulapi.Report{Failed: 1}, gondulapi.Errorf(400, "Invalid search string, need family/%%s/shortname/%%s, got family/%s, err: %v", element, err)
+// Validate requires Family and Shortname in the data for POST, since it
+// has no URL to take them from.
+func (ds Docstub) Validate(method string) error {
+	if method != "POST" {
+		return nil
+	}
+	var failed gondulapi.FieldErrors
+	if ds.Family == nil || *ds.Family == "" {
+		failed = append(failed, gondulapi.FieldError{Field: "Family", Message: "is required for POST"})
 	}
-	return db.Upsert(ds, "docs", "family", "=", family, "shortname", "=", shortname)
+	if ds.Shortname == nil || *ds.Shortname == "" {
+		failed = append(failed, gondulapi.FieldError{Field: "Shortname", Message: "is required for POST"})
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
 }
 
-func (ds Docstub) Post() (gondulapi.Report, error) {
-	if ds.Family == nil || *ds.Family == "" || ds.Shortname == nil || *ds.Shortname == "" {
-		return gondulapi.Report{Failed: 1}, gondulapi.Errorf(400, "Need to provide Family and Shortname for doc stubs")
-	}
-	return db.Upsert(ds, "docs", "family", "=", ds.Family, "shortname", "=", ds.Shortname)
+func (ds Docstub) Post(ctx context.Context) (gondulapi.Report, error) {
+	return db.UpsertContext(ctx, ds, "docs", "family", "=", ds.Family, "shortname", "=", ds.Shortname)
 }
 
-func (d *Docs) Get(element string) (gondulapi.Report, error) {
-	return db.SelectMany(d, "docs", "family", "=", element)
+func (d *Docs) Get(ctx context.Context, element string) (gondulapi.Report, error) {
+	return db.SelectManyContext(ctx, d, "docs", "family", "=", element)
 }
 
 // Get an array of tests associated with a station, uses the
-// url path /test/track/$TRACKID/station/$STATIONID for readability.
-func (st *StationTests) Get(element string) (gondulapi.Report, error) {
-	track, station := "", 0
-	element = strings.Replace(element, "/", " ", -1)
-	_, err := fmt.Sscanf(element, "%s station %d", &track, &station)
-	if err != nil {
-		return gondulapi.Report{}, gondulapi.Errorf(400, "Invalid search string, need track/%%s/station/%%d, got station/%s, err: %v", element, err)
-	}
-	return db.SelectMany(st, "results", "track", "=", track, "station", "=", station)
-}
-
-// mkid is a convenience-function to parse the URL for a test and backfill
-// it into t, building t.id while we're at it which can be parsed to any
-// gondulapi.db function that accepts variadic search arguments.
-func (t *Test) mkid(element string) error {
-	t.Track = new(string)
-	t.Station = new(int)
-	t.Hash = new(string)
-	element = strings.Replace(element, "/", " ", -1)
-	_, err := fmt.Sscanf(element, "track %s station %d hash %s", t.Track, t.Station, t.Hash)
-	if err != nil {
-		return gondulapi.Errorf(400, "Invalid search string, need track/%%s/station/%%d/hash/%%s, got %s, err: %v", element, err)
-	}
+// url path /tests/track/$TRACKID/station/$STATIONID for readability.
+func (st *StationTests) Get(request *gondulapi.Request) (gondulapi.Report, error) {
+	return db.SelectManyContext(request.Context, st, "results", "track", "=", request.Params["track"], "station", "=", request.Params["station"])
+}
+
+// mkid is a convenience-function to backfill the track, station and hash
+// from the URL into t, building t.id while we're at it which can be
+// parsed to any gondulapi.db function that accepts variadic search
+// arguments.
+func (t *Test) mkid(params gondulapi.Params) {
+	track, station, hash := params["track"].(string), params["station"].(int), params["hash"].(string)
+	t.Track = &track
+	t.Station = &station
+	t.Hash = &hash
 	t.id = []interface{}{"track", "=", t.Track, "station", "=", t.Station, "hash", "=", t.Hash}
-	return nil
 }
 
 // Get a single test
-func (t *Test) Get(element string) (gondulapi.Report, error) {
-	if err := t.mkid(element); err != nil {
-		log.Printf("mkid failed: %v",err)
-
-		return gondulapi.Report{Failed:1},err
-	}
-	return db.Get(t, "results", t.id...)
+func (t *Test) Get(request *gondulapi.Request) (gondulapi.Report, error) {
+	t.mkid(request.Params)
+	return db.GetContext(request.Context, t, "results", t.id...)
 }
 
 // Put a single test - uses upsert: if it exists, it is updated, if it
 // doesn't it is added.
-func (t Test) Put(element string) (gondulapi.Report, error) {
-	if err := t.mkid(element); err != nil {
-		return gondulapi.Report{Failed: 1}, err
+func (t Test) Put(request *gondulapi.Request) (gondulapi.Report, error) {
+	t.mkid(request.Params)
+	return db.UpsertContext(request.Context, t, "results", t.id...)
+}
+
+// Validate requires the track, station and hash in the data for POST,
+// since it ignores the URL.
+func (t Test) Validate(method string) error {
+	if method != "POST" {
+		return nil
+	}
+	var failed gondulapi.FieldErrors
+	if t.Track == nil || *t.Track == "" {
+		failed = append(failed, gondulapi.FieldError{Field: "Track", Message: "is required for POST"})
+	}
+	if t.Station == nil || *t.Station == 0 {
+		failed = append(failed, gondulapi.FieldError{Field: "Station", Message: "must be set to a non-0 value for POST"})
+	}
+	if t.Hash == nil || *t.Hash == "" {
+		failed = append(failed, gondulapi.FieldError{Field: "Hash", Message: "is required for POST"})
+	}
+	if len(failed) > 0 {
+		return failed
 	}
-	return db.Upsert(t, "results", t.id...)
+	return nil
 }
 
 // Post a single test - Also uses upsert, but ignores the URL and requires
-// all fields to be present in the data instead.
-func (t Test) Post() (gondulapi.Report, error) {
-	if t.Track == nil || *t.Track == "" || t.Station == nil || *t.Station == 0 || t.Hash == nil || *t.Hash == "" {
-		return gondulapi.Report{Failed: 1}, gondulapi.Errorf(400, "POST must define both track and station as non-0 values")
-	}
-	return db.Upsert(t, "results", "track", "=", t.Track, "station", "=", t.Station, "hash", "=", t.Hash)
+// all fields to be present in the data instead, see Validate.
+func (t Test) Post(ctx context.Context) (gondulapi.Report, error) {
+	return db.UpsertContext(ctx, t, "results", "track", "=", t.Track, "station", "=", t.Station, "hash", "=", t.Hash)
 }
 
 // Delete all tests that match the url (which SHOULD be just one)
-func (t Test) Delete(element string) (gondulapi.Report, error) {
-	if err := t.mkid(element); err != nil {
-		return gondulapi.Report{Failed: 1}, err
-	}
-	return db.Delete("results", t.id...)
+func (t Test) Delete(request *gondulapi.Request) (gondulapi.Report, error) {
+	t.mkid(request.Params)
+	return db.DeleteContext(request.Context, "results", t.id...)
+}
+
+// Validate is Test.Validate.
+func (t TestPost) Validate(method string) error {
+	return Test(t).Validate(method)
+}
+
+// Post is Test.Post.
+func (t TestPost) Post(ctx context.Context) (gondulapi.Report, error) {
+	return Test(t).Post(ctx)
 }
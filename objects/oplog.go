@@ -20,6 +20,7 @@ Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
 package objects
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -31,11 +32,11 @@ import (
 // Oplog is a single oplog entry. It can be created with POST, or updated
 // with PUT referencing the id.
 type Oplog struct {
-	Id       *int
-	Time     *time.Time
-	Systems  *string
-	Username *string
-	Log      *string
+	Id       *int       `filter:"eq,gt,lt" cursor:"desc"`
+	Time     *time.Time `filter:"gt,ge,lt,le"`
+	Systems  *string    `filter:"eq,like"`
+	Username *string    `filter:"eq"`
+	Log      *string    `filter:"like"`
 }
 
 // Oplogs is an array of oplog entries, and can only be fetched (with Get).
@@ -43,11 +44,12 @@ type Oplogs []Oplog
 
 func init() {
 	receiver.AddHandler("/oplog/", func() interface{} { return &Oplog{} })
-	receiver.AddHandler("/oplog", func() interface{} { return &Oplogs{} })
+	receiver.AddHandler("/oplog", func() interface{} { return &Oplogs{} }, receiver.PageSize(100, 0), receiver.TotalCount())
+	gondulapi.AddCheck("oplog", db.CheckTables("oplog"))
 }
 
-func (o *Oplog) Get(element string) (gondulapi.Report, error) {
-	return db.Get(o, "oplog", "id", "=", element)
+func (o *Oplog) Get(ctx context.Context, element string) (gondulapi.Report, error) {
+	return db.GetContext(ctx, o, "oplog", "id", "=", element)
 }
 
 func intmatcher(element *string, i **int) error {
@@ -70,25 +72,30 @@ func intmatcher(element *string, i **int) error {
 	return nil
 }
 
-func (o Oplog) Put(element string) (gondulapi.Report, error) {
+func (o Oplog) Put(ctx context.Context, element string) (gondulapi.Report, error) {
 	err := intmatcher(&element, &o.Id)
 	if err != nil {
 		return gondulapi.Report{Failed: 1}, err
 	}
-	return db.Upsert(o, "oplog", "id", "=", element)
+	return db.UpsertContext(ctx, o, "oplog", "id", "=", element)
 }
 
-func (o Oplog) Post() (gondulapi.Report, error) {
-	return db.Insert(o, "oplog")
+func (o Oplog) Post(ctx context.Context) (gondulapi.Report, error) {
+	return db.InsertContext(ctx, o, "oplog")
 }
 
-// Delete the switch
-func (o Oplog) Delete(element string) (gondulapi.Report, error) {
-	return db.Delete(element, "id", "oplog")
+// Patch updates just the fields changed by a PATCH.
+func (o Oplog) Patch(ctx context.Context, element string, fields []string) (gondulapi.Report, error) {
+	return db.PatchContext(ctx, o, "oplog", fields, "id", "=", element)
 }
 
-// Get multiple switches. Relies on s being a pointer to an array of
-// structs (which it is).
-func (os *Oplogs) Get(element string) (gondulapi.Report, error) {
-	return db.SelectMany(os, "oplog")
+// Delete the oplog entry
+func (o Oplog) Delete(ctx context.Context, element string) (gondulapi.Report, error) {
+	return db.DeleteContext(ctx, "oplog", "id", "=", element)
+}
+
+// Get multiple oplog entries. Can be filtered on the fields tagged with
+// filter.
+func (os *Oplogs) Get(request *gondulapi.Request) (gondulapi.Report, error) {
+	return db.SelectQueryContext(request.Context, os, "oplog", request.Query)
 }
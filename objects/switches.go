@@ -20,31 +20,32 @@ Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
 package objects
 
 import (
+	"context"
 	"time"
 
 	"github.com/gathering/gondulapi"
 	"github.com/gathering/gondulapi/db"
+	"github.com/gathering/gondulapi/log"
 	"github.com/gathering/gondulapi/receiver"
 	"github.com/gathering/gondulapi/types"
-	"github.com/gathering/gondulapi/log"
 )
 
 // Switch represents a single switch, or box. It can be updated in bulk or
 // singular.
 type Switch struct {
-	Sysname       *string
-	MgmtIP4       *types.IP  `column:"mgmt_v4_addr"`
-	MgmtIP6       *types.IP  `column:"mgmt_v6_addr"`
-	LastUpdated   *time.Time `column:"last_updated"`
+	Sysname       *string    `filter:"eq,ne,like" cursor:"asc"`
+	MgmtIP4       *types.IP  `column:"mgmt_v4_addr" validate:"ip4"`
+	MgmtIP6       *types.IP  `column:"mgmt_v6_addr" validate:"ip6"`
+	LastUpdated   *time.Time `column:"last_updated" filter:"gt,ge,lt,le"`
 	PollFrequency *string    `column:"poll_frequency"`
-	Locked        *bool
-	Deleted       *bool
-	DistroName    *string `column:"distro_name"`
-	DistroPhyPort *string `column:"distro_phy_port"`
+	Locked        *bool      `filter:"eq"`
+	Deleted       *bool      `filter:"eq"`
+	DistroName    *string    `column:"distro_name" filter:"eq,ne,like"`
+	DistroPhyPort *string    `column:"distro_phy_port"`
 	Tags          *types.Jsonb
 	Community     *string
-	TrafficVlan   *int `column:"traffic_vlan"`
-	MgmtVlan      *int `column:"mgmt_vlan"`
+	TrafficVlan   *int `column:"traffic_vlan" filter:"eq"`
+	MgmtVlan      *int `column:"mgmt_vlan" filter:"eq"`
 	Placement     *types.Box
 }
 
@@ -55,13 +56,14 @@ type Switches []Switch
 func init() {
 	receiver.AddHandler("/switches/", func() interface{} { return &Switch{} })
 	receiver.AddHandler("/switches", func() interface{} { return &Switches{} })
+	gondulapi.AddCheck("switches", db.CheckTables("switches"))
 }
 
 // Get a single switch from the database and return it. db.Get is a
 // convenience that returns 404 if it doesn't exist and 400 if element is
 // blank.
-func (s *Switch) Get(element string) (gondulapi.Report, error) {
-	return db.Get(s, "switches", "sysname", "=", element)
+func (s *Switch) Get(ctx context.Context, element string) (gondulapi.Report, error) {
+	return db.GetContext(ctx, s, "switches", "sysname", "=", element)
 }
 
 func strmatcher(element *string, s **string) error {
@@ -84,42 +86,48 @@ func strmatcher(element *string, s **string) error {
 // Put will update or add a provided switch. If the name on the url and the
 // one contained in the data doesn't match, the switch will be renamed from
 // what's on the url to what's in the data.
-func (s Switch) Put(element string) (gondulapi.Report, error) {
+func (s Switch) Put(ctx context.Context, element string) (gondulapi.Report, error) {
 	err := strmatcher(&element, &s.Sysname)
 	if err != nil {
 		return gondulapi.Report{Failed: 1}, err
 	}
 	if *s.Sysname != element {
-		log.Printf("Renaming switch from %s to %s", element, *s.Sysname)
+		log.Context(ctx).Printf("Renaming switch from %s to %s", element, *s.Sysname)
 	}
-	return db.Upsert(element, "sysname", "switches", s)
+	return db.UpsertContext(ctx, s, "switches", "sysname", "=", element)
 }
 
 // Post will either update or insert a switch entirely contained in the
 // provided object. For switches, it's the same as Put without an element.
-func (s Switch) Post() (gondulapi.Report, error) {
-	return s.Put("")
+func (s Switch) Post(ctx context.Context) (gondulapi.Report, error) {
+	return s.Put(ctx, "")
+}
+
+// Patch updates just the fields changed by a PATCH, which can also set
+// them to null.
+func (s Switch) Patch(ctx context.Context, element string, fields []string) (gondulapi.Report, error) {
+	return db.PatchContext(ctx, s, "switches", fields, "sysname", "=", element)
 }
 
 // Delete the switch
-func (s Switch) Delete(element string) (gondulapi.Report, error) {
-	return db.Delete(element, "sysname", "switches")
+func (s Switch) Delete(ctx context.Context, element string) (gondulapi.Report, error) {
+	return db.DeleteContext(ctx, "switches", "sysname", "=", element)
 }
 
 // Get multiple switches. Relies on s being a pointer to an array of
-// structs (which it is).
-func (s *Switches) Get(element string) (gondulapi.Report, error) {
-	return db.SelectMany(s, "switches")
+// structs (which it is). Can be filtered on the fields tagged with filter.
+func (s *Switches) Get(request *gondulapi.Request) (gondulapi.Report, error) {
+	return db.SelectQueryContext(request.Context, s, "switches", request.Query)
 }
 
 // Post all the provided switches in bulk.
-func (s Switches) Post() (gondulapi.Report, error) {
+func (s Switches) Post(ctx context.Context) (gondulapi.Report, error) {
 	sn := []Switch(s)
 	ret := gondulapi.Report{}
 	for idx := range sn {
-		report, err := sn[idx].Post()
+		report, err := sn[idx].Post(ctx)
 		if err != nil {
-			log.Printf("Single-item failed, but moving on with switch-update: %s", err)
+			log.Context(ctx).Printf("Single-item failed, but moving on with switch-update: %s", err)
 			ret.Failed++
 		} else {
 			ret.Ok++
@@ -0,0 +1,77 @@
+/*
+Gondul GO API, error replies
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package gondulapi
+
+import (
+	"net/http"
+)
+
+// Problem is how errors are sent to clients, as application/problem+json
+// described by RFC 7807. The receiver makes one from the Error returned
+// by an object, filling in Instance and RequestID. Objects wanting their
+// own Type can use a Problem as the message, e.g.:
+//
+//	return report, gondulapi.Errori(409, gondulapi.Problem{
+//		Type:   "https://example.com/problems/locked",
+//		Detail: "The switch is locked",
+//	})
+type Problem struct {
+	Type      string      `json:"type"`                // URI identifying the kind of problem, "about:blank" if it is just the status
+	Title     string      `json:"title"`               // Short summary of Type, the status text for "about:blank"
+	Status    int         `json:"status"`              // HTTP status code
+	Detail    string      `json:"detail,omitempty"`    // What went wrong this time
+	Instance  string      `json:"instance,omitempty"`  // Path of the request
+	Errors    FieldErrors `json:"errors,omitempty"`    // Fields that were invalid
+	RequestID string      `json:"requestId,omitempty"` // See X-Request-ID
+}
+
+// String returns the detail, so a Problem can be the message of an Error.
+func (p Problem) String() string {
+	return p.Detail
+}
+
+// Problem returns e as a Problem. The status is the code of e, or 500 if
+// it has none. Messages that are a Problem are completed, and FieldErrors
+// are listed as Errors.
+func (e Error) Problem() Problem {
+	var p Problem
+	switch m := e.Message.(type) {
+	case Problem:
+		p = m
+	case FieldErrors:
+		p.Detail = m.String()
+		p.Errors = m
+	default:
+		p.Detail = e.Error()
+	}
+	if p.Status == 0 {
+		p.Status = e.Code
+	}
+	if p.Status == 0 {
+		p.Status = 500
+	}
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" && p.Type == "about:blank" {
+		p.Title = http.StatusText(p.Status)
+	}
+	return p
+}
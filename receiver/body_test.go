@@ -0,0 +1,128 @@
+/*
+Gondul GO API, receiver request body tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+func init() {
+	receiver.AddHandler("/small/", func() interface{} { return &thing{} }, receiver.MaxBodySize(16))
+}
+
+// truncated sends a PUT to path claiming a larger body than it sends, and
+// returns the status code of the reply.
+func truncated(t *testing.T, srv *httptest.Server, path string, body string) int {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	h.CheckEqual(t, err, nil)
+	defer conn.Close()
+	fmt.Fprintf(conn, "PUT %s HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", path, len(body)+10, body)
+	conn.(*net.TCPConn).CloseWrite()
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	h.CheckEqual(t, err, nil)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestMaxBodySize(t *testing.T) {
+	defer func() { gondulapi.Config.MaxBodySize = 0 }()
+	small := `{"Name": "x"}`
+	large := `{"Name": "xxxxxxxxxx"}`
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte(`{"Name": "` + strings.Repeat("x", 1000) + `"}`))
+	zw.Close()
+
+	cases := []struct {
+		config   int64
+		path     string
+		body     string
+		chunked  bool
+		encoding string
+		code     int
+	}{
+		// The default is large enough for anything sane.
+		{0, "/thing/x", large, false, "", 200},
+		{0, "/thing/x", large, true, "", 200},
+		// The config lowers it for everyone, with and without a
+		// Content-Length.
+		{16, "/thing/x", small, false, "", 200},
+		{16, "/thing/x", large, false, "", 413},
+		{16, "/thing/x", large, true, "", 413},
+		// The handler overrides the config.
+		{0, "/small/x", small, false, "", 200},
+		{0, "/small/x", large, false, "", 413},
+		{0, "/small/x", large, true, "", 413},
+		{8, "/small/x", small, false, "", 200},
+		{1 << 20, "/small/x", large, true, "", 413},
+		// The limit applies after decompressing.
+		{0, "/small/x", gzipped.String(), false, "gzip", 413},
+		{0, "/thing/x", gzipped.String(), false, "gzip", 200},
+	}
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+	for _, c := range cases {
+		gondulapi.Config.MaxBodySize = c.config
+		req, _ := http.NewRequest("PUT", srv.URL+c.path, strings.NewReader(c.body))
+		if c.chunked {
+			req.ContentLength = -1
+		}
+		if c.encoding != "" {
+			req.Header.Set("Content-Encoding", c.encoding)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		h.CheckEqual(t, err, nil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		h.CheckEqual(t, resp.StatusCode, c.code)
+	}
+
+	// A body no codec can read is a 415, as is one in an unknown
+	// content coding.
+	for _, header := range []string{"Content-Type", "Content-Encoding"} {
+		req, _ := http.NewRequest("PUT", srv.URL+"/thing/x", strings.NewReader(small))
+		req.Header.Set(header, "application/x-nonsense")
+		resp, err := http.DefaultClient.Do(req)
+		h.CheckEqual(t, err, nil)
+		resp.Body.Close()
+		h.CheckEqual(t, resp.StatusCode, 415)
+	}
+
+	// A body that ends before its Content-Length is a 400, unless the
+	// Content-Length is too large to begin with.
+	gondulapi.Config.MaxBodySize = 0
+	h.CheckEqual(t, truncated(t, srv, "/thing/x", small), 400)
+	h.CheckEqual(t, truncated(t, srv, "/small/x", "{}"), 400)
+	h.CheckEqual(t, truncated(t, srv, "/small/x", small), 413)
+}
@@ -0,0 +1,441 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gathering/gondulapi"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Codec converts between Go values and a wire format other than JSON.
+// Marshal gets the same values encoding/json would, and Unmarshal should
+// fill in v the way json.Unmarshal does.
+//
+// The easiest way to get that right is to go through JSON, which is what
+// the built-in codecs do: that way MarshalJSON, MarshalText and json tags
+// work the same in every format, and the types in gondulapi/types need
+// nothing extra.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// format is a registered Codec. The first media type is the one used in
+// replies, the rest are accepted aliases.
+type format struct {
+	name       string
+	mediaTypes []string
+	codec      Codec
+}
+
+// formats are the registered codecs, in order of preference when the
+// client accepts several. JSON is always first.
+var formats = []format{
+	{"json", []string{"application/json"}, jsonCodec{}},
+	{"yaml", []string{"application/yaml", "application/x-yaml", "text/yaml"}, yamlCodec{}},
+	{"msgpack", []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}, msgpackCodec{}},
+	{"csv", []string{"text/csv"}, csvCodec{}},
+}
+
+// RegisterCodec makes codec available to clients as name, for ?format=,
+// and as the media types listed, for Accept and Content-Type. Registering
+// an existing name replaces it. It is not safe to call once the receiver
+// is started.
+func RegisterCodec(codec Codec, name string, mediaTypes ...string) {
+	if len(mediaTypes) == 0 {
+		panic(fmt.Sprintf("receiver: RegisterCodec(%s) needs at least one media type", name))
+	}
+	f := format{name, mediaTypes, codec}
+	for idx := range formats {
+		if formats[idx].name == name {
+			formats[idx] = f
+			return
+		}
+	}
+	formats = append(formats, f)
+}
+
+// CodecFor returns the codec registered as name, e.g. "yaml".
+func CodecFor(name string) (Codec, bool) {
+	for _, f := range formats {
+		if f.name == name {
+			return f.codec, true
+		}
+	}
+	return nil, false
+}
+
+// names lists the registered formats, for error messages.
+func names() string {
+	list := make([]string, 0, len(formats))
+	for _, f := range formats {
+		list = append(list, f.name)
+	}
+	return strings.Join(list, ", ")
+}
+
+// negotiate picks the format of the reply, either from ?format= or from
+// the Accept header. Without either, it's JSON.
+func negotiate(r *http.Request) (format, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		for _, f := range formats {
+			if f.name == name {
+				return f, nil
+			}
+		}
+		return formats[0], gondulapi.Errorf(400, "Unknown format %q, use one of %s", name, names())
+	}
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formats[0], nil
+	}
+	type candidate struct {
+		mediaType string
+		q         float64
+	}
+	candidates := make([]candidate, 0)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{mediaType, q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	for _, c := range candidates {
+		for _, f := range formats {
+			for _, mt := range f.mediaTypes {
+				if c.mediaType == "*/*" || c.mediaType == mt || (strings.HasSuffix(c.mediaType, "/*") && strings.HasPrefix(mt, c.mediaType[:len(c.mediaType)-1])) {
+					return f, nil
+				}
+			}
+		}
+	}
+	return formats[0], gondulapi.Errorf(406, "Can't reply with any of %q, use one of %s", accept, names())
+}
+
+// decoder picks the codec for a request body with the given Content-Type.
+// Bodies without one are JSON.
+func decoder(contentType string) (Codec, error) {
+	if contentType == "" {
+		return formats[0].codec, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, gondulapi.Errorf(415, "Invalid Content-Type %q: %v", contentType, err)
+	}
+	for _, f := range formats {
+		for _, mt := range f.mediaTypes {
+			if mediaType == mt {
+				return f.codec, nil
+			}
+		}
+	}
+	return nil, gondulapi.Errorf(415, "Unsupported Content-Type %q, use one of %s", mediaType, names())
+}
+
+// unmarshal decodes the request body onto v, using the codec picked by
+// its Content-Type. JSON bodies are checked strictly if the handler asks
+// for it, see StrictJSON.
+func unmarshal(input input, v interface{}) error {
+	codec, err := decoder(input.contentType)
+	if err != nil {
+		return err
+	}
+	if _, ok := codec.(jsonCodec); ok && input.strict {
+		return strictUnmarshal(input.data, v)
+	}
+	return codec.Unmarshal(input.data, v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return jsonError(json.Unmarshal(data, v))
+}
+
+// generic returns v as encoding/json sees it: maps, slices, strings,
+// bools, int64s and float64s. It's what the other codecs marshal, so they
+// get the json names and all the custom marshalling for free.
+func generic(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := decode(b, &doc); err != nil {
+		return nil, err
+	}
+	return numbers(doc), nil
+}
+
+// numbers replaces the json.Numbers in doc with int64 where possible, and
+// float64 otherwise.
+func numbers(doc interface{}) interface{} {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = numbers(v[k])
+		}
+	case []interface{}:
+		for idx := range v {
+			v[idx] = numbers(v[idx])
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return doc
+}
+
+// viaJSON is the inverse of generic: it stores doc, as decoded by another
+// codec, onto v by way of encoding/json.
+func viaJSON(doc interface{}, v interface{}) error {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	// The offsets are in the JSON made from doc, which means nothing to
+	// the client.
+	return withoutOffsets(jsonError(json.Unmarshal(b, v)))
+}
+
+// withoutOffsets clears the offsets of the FieldErrors in err, for errors
+// about JSON the client didn't send.
+func withoutOffsets(err error) error {
+	var gerr gondulapi.Error
+	if errors.As(err, &gerr) {
+		if fields, ok := gerr.Message.(gondulapi.FieldErrors); ok {
+			for idx := range fields {
+				fields[idx].Offset = 0
+			}
+		}
+	}
+	return err
+}
+
+type yamlCodec struct{}
+
+func (yamlCodec) Marshal(v interface{}) ([]byte, error) {
+	doc, err := generic(v)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+func (yamlCodec) Unmarshal(data []byte, v interface{}) error {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return gondulapi.Errorf(400, "Invalid YAML: %v", err)
+	}
+	return viaJSON(doc, v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	doc, err := generic(v)
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(doc)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	var doc interface{}
+	if err := msgpack.Unmarshal(data, &doc); err != nil {
+		return gondulapi.Errorf(400, "Invalid MessagePack: %v", err)
+	}
+	return viaJSON(doc, v)
+}
+
+// csvCodec handles lists of objects, one per row, with the JSON names of
+// the fields as the header. Strings are written as is, null as an empty
+// cell and everything else as JSON, e.g. a types.Box is written as
+// {"X1":1,"X2":2,"Y1":3,"Y2":4}.
+type csvCodec struct{}
+
+// object splits a JSON object into its keys, in order, and values.
+func object(raw json.RawMessage) ([]string, map[string]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("CSV can only hold lists of objects")
+	}
+	keys := make([]string, 0)
+	values := make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		values[key] = value
+	}
+	return keys, values, nil
+}
+
+func (csvCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("CSV can only hold lists of objects")
+	}
+	columns := make([]string, 0)
+	seen := make(map[string]bool)
+	rows := make([]map[string]json.RawMessage, 0, len(list))
+	for _, raw := range list {
+		keys, values, err := object(raw)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+		}
+		rows = append(rows, values)
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(columns)
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for idx, column := range columns {
+			value := row[column]
+			switch {
+			case len(value) == 0 || string(value) == "null":
+			case value[0] == '"':
+				json.Unmarshal(value, &record[idx])
+			default:
+				record[idx] = string(value)
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// Unmarshal reads the rows back, using the type of v to tell strings from
+// other values: a column that is a string in JSON is always read as one,
+// others are read as JSON if they are valid JSON. Empty cells are left
+// out. If v isn't a list, there must be exactly one row.
+func (csvCodec) Unmarshal(data []byte, v interface{}) error {
+	t := reflect.ValueOf(v)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		if t.IsNil() {
+			break
+		}
+		t = t.Elem()
+	}
+	et := t.Type()
+	for et.Kind() == reflect.Ptr {
+		et = et.Elem()
+	}
+	list := et.Kind() == reflect.Slice
+	if list {
+		et = et.Elem()
+		for et.Kind() == reflect.Ptr {
+			et = et.Elem()
+		}
+	}
+	if et.Kind() != reflect.Struct {
+		return gondulapi.Errorf(415, "CSV can only hold objects")
+	}
+	props := properties(et)
+
+	r := csv.NewReader(bytes.NewReader(data))
+	header, err := r.Read()
+	if err != nil {
+		return gondulapi.Errorf(400, "Invalid CSV, unable to read the header: %v", err)
+	}
+	rows := make([]map[string]json.RawMessage, 0)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return gondulapi.Errorf(400, "Invalid CSV: %v", err)
+		}
+		row := make(map[string]json.RawMessage)
+		for idx, cell := range record {
+			if cell == "" {
+				continue
+			}
+			prop, _ := props[header[idx]].(map[string]interface{})
+			if prop["type"] != "string" && json.Valid([]byte(cell)) {
+				row[header[idx]] = json.RawMessage(cell)
+				continue
+			}
+			b, _ := json.Marshal(cell)
+			row[header[idx]] = b
+		}
+		rows = append(rows, row)
+	}
+	if list {
+		return viaJSON(rows, v)
+	}
+	if len(rows) != 1 {
+		return gondulapi.Errorf(400, "Invalid CSV, expected a single row, got %d", len(rows))
+	}
+	return viaJSON(rows[0], v)
+}
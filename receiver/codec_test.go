@@ -0,0 +1,115 @@
+/*
+Gondul GO API, codec tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+	"github.com/gathering/gondulapi/types"
+)
+
+type system struct {
+	Sysname   string     `json:"sysname"`
+	Ip        *types.IP  `json:"ip"`
+	Vlan      *int       `json:"vlan"`
+	Placement *types.Box `json:"placement"`
+	Tags      types.Jsonb
+	Seen      time.Time
+	Number    string // looks like a number in CSV, but isn't
+}
+
+func mksystems(t *testing.T) []system {
+	t.Helper()
+	ip, err := types.NewIP("fe80::77d6:6a51:13d6:b1ef/64")
+	h.CheckEqual(t, err, nil)
+	vlan := 42
+	return []system{
+		{
+			Sysname:   "e1-1",
+			Ip:        &ip,
+			Vlan:      &vlan,
+			Placement: &types.Box{X1: 1, X2: 2, Y1: 3, Y2: 4},
+			Tags:      types.Jsonb{Data: map[string]interface{}{"rack": "a", "list": []interface{}{1.5, "x"}}},
+			Seen:      time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC),
+			Number:    "0042",
+		},
+		{
+			Sysname: "e1-2, with \"quotes\"",
+			Tags:    types.Jsonb{Data: nil},
+		},
+	}
+}
+
+func tojson(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	h.CheckEqual(t, err, nil)
+	return string(b)
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, name := range []string{"json", "yaml", "msgpack", "csv"} {
+		codec, ok := receiver.CodecFor(name)
+		h.CheckEqual(t, ok, true)
+		in := mksystems(t)
+		b, err := codec.Marshal(in)
+		h.CheckEqual(t, err, nil)
+		out := make([]system, 0)
+		err = codec.Unmarshal(b, &out)
+		h.CheckEqual(t, err, nil)
+		h.CheckEqual(t, tojson(t, out), tojson(t, in))
+
+		single := in[0]
+		b, err = codec.Marshal(&single)
+		if name == "csv" {
+			h.CheckNotEqual(t, err, nil)
+			continue
+		}
+		h.CheckEqual(t, err, nil)
+		var back system
+		err = codec.Unmarshal(b, &back)
+		h.CheckEqual(t, err, nil)
+		h.CheckEqual(t, tojson(t, back), tojson(t, single))
+	}
+}
+
+func TestCSV(t *testing.T) {
+	codec, _ := receiver.CodecFor("csv")
+	b, err := codec.Marshal(mksystems(t)[:1])
+	h.CheckEqual(t, err, nil)
+	h.CheckEqual(t, string(b), `sysname,ip,vlan,placement,Tags,Seen,Number
+e1-1,fe80::77d6:6a51:13d6:b1ef/64,42,"{""X1"":1,""X2"":2,""Y1"":3,""Y2"":4}","{""list"":[1.5,""x""],""rack"":""a""}",2020-04-01T12:00:00Z,0042
+`)
+
+	var one system
+	err = codec.Unmarshal([]byte("sysname,vlan\ne1-3,7\n"), &one)
+	h.CheckEqual(t, err, nil)
+	h.CheckEqual(t, one.Sysname, "e1-3")
+	h.CheckEqual(t, *one.Vlan, 7)
+
+	err = codec.Unmarshal([]byte("sysname,vlan\ne1-3,seven\n"), &one)
+	h.CheckNotEqual(t, err, nil)
+	err = codec.Unmarshal([]byte("sysname\na\nb\n"), &one)
+	h.CheckNotEqual(t, err, nil)
+}
@@ -0,0 +1,158 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gathering/gondulapi"
+	"github.com/klauspost/compress/zstd"
+)
+
+// defaultCompressMinSize is used if the config doesn't set a threshold
+// for compression. Smaller replies aren't worth the effort.
+const defaultCompressMinSize = 1024
+
+// encodings are the content codings replies can be compressed with, in
+// the order the server prefers them.
+var encodings = []string{"zstd", "gzip"}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+)
+
+// compressMinSize returns the smallest reply that is compressed, or -1 if
+// compression is disabled.
+func compressMinSize() int {
+	min := gondulapi.Config.CompressMinSize
+	if min == 0 {
+		return defaultCompressMinSize
+	}
+	if min < 0 {
+		return -1
+	}
+	return min
+}
+
+// acceptEncoding picks the encoding to use for a reply of size bytes,
+// based on the Accept-Encoding header of r. It returns the empty string
+// if the reply should not be compressed, either because it is too small
+// or because the client doesn't accept any of the encodings.
+func acceptEncoding(r *http.Request, size int) string {
+	min := compressMinSize()
+	if min < 0 || size < min {
+		return ""
+	}
+	quality := map[string]float64{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		params = strings.TrimSpace(params)
+		if strings.HasPrefix(params, "q=") {
+			v, err := strconv.ParseFloat(params[2:], 64)
+			if err != nil {
+				continue
+			}
+			q = v
+		}
+		quality[name] = q
+	}
+	best, bestq := "", 0.0
+	for _, enc := range encodings {
+		q, ok := quality[enc]
+		if !ok {
+			q, ok = quality["*"]
+		}
+		if ok && q > bestq {
+			best, bestq = enc, q
+		}
+	}
+	return best
+}
+
+// compress encodes b with enc, which is one of encodings.
+func compress(enc string, b []byte) ([]byte, error) {
+	switch enc {
+	case "zstd":
+		zstdOnce.Do(func() {
+			zstdEncoder, _ = zstd.NewWriter(nil)
+		})
+		return zstdEncoder.EncodeAll(b, make([]byte, 0, len(b)/2)), nil
+	case "gzip":
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(b); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return b, nil
+}
+
+// gzipBody is a gunzipped request body. Closing it closes both the
+// gzip.Reader and the body it reads.
+type gzipBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (g gzipBody) Close() error {
+	err := g.Reader.Close()
+	if berr := g.body.Close(); err == nil {
+		err = berr
+	}
+	return err
+}
+
+// decompress returns a reader for the body of r, which is gunzipped if
+// the client sent it with Content-Encoding: gzip. Other encodings are a
+// 415, and a body that isn't gzip after all a 400. The body is nil if a
+// gzipped body turns out to be empty. The caller closes the body.
+func decompress(w http.ResponseWriter, r *http.Request) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return r.Body, nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, gondulapi.Errorf(400, "Unable to decompress request body: %v", err)
+		}
+		return gzipBody{zr, r.Body}, nil
+	}
+	w.Header().Set("Accept-Encoding", "gzip")
+	return nil, gondulapi.Errorf(415, "Unsupported Content-Encoding %q, only gzip is supported", r.Header.Get("Content-Encoding"))
+}
@@ -0,0 +1,142 @@
+/*
+Gondul GO API, receiver compression tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+	"github.com/klauspost/compress/zstd"
+)
+
+func fetch(t *testing.T, url string, encoding string, etag string) (*http.Response, []byte) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Accept-Encoding", encoding)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	return resp, b
+}
+
+func TestCompression(t *testing.T) {
+	gondulapi.Config.CompressMinSize = 100
+	defer func() { gondulapi.Config.CompressMinSize = 0 }()
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	name := strings.Repeat("x", 200)
+	want := "{\"Name\":\"" + name + "\"}\n"
+
+	resp, b := fetch(t, srv.URL+"/thing/"+name, "gzip, zstd", "")
+	h.CheckEqual(t, resp.Header.Get("Content-Encoding"), "zstd")
+	h.CheckEqual(t, strings.Join(resp.Header.Values("Vary"), ", "), "Accept, Accept-Encoding")
+	zr, _ := zstd.NewReader(nil)
+	plain, err := zr.DecodeAll(b, nil)
+	h.CheckEqual(t, err, nil)
+	h.CheckEqual(t, string(plain), want)
+	zstdTag := resp.Header.Get("ETag")
+	h.CheckEqual(t, strings.HasSuffix(zstdTag, "-zstd\""), true)
+
+	resp, b = fetch(t, srv.URL+"/thing/"+name, "zstd;q=0, gzip;q=0.5", "")
+	h.CheckEqual(t, resp.Header.Get("Content-Encoding"), "gzip")
+	gr, err := gzip.NewReader(bytes.NewReader(b))
+	h.CheckEqual(t, err, nil)
+	plain, _ = io.ReadAll(gr)
+	h.CheckEqual(t, string(plain), want)
+	gzipTag := resp.Header.Get("ETag")
+	h.CheckNotEqual(t, gzipTag, zstdTag)
+
+	resp, b = fetch(t, srv.URL+"/thing/"+name, "identity", "")
+	h.CheckEqual(t, resp.Header.Get("Content-Encoding"), "")
+	h.CheckEqual(t, string(b), want)
+	plainTag := resp.Header.Get("ETag")
+	h.CheckNotEqual(t, plainTag, gzipTag)
+
+	// Each encoding only matches its own ETag.
+	resp, _ = fetch(t, srv.URL+"/thing/"+name, "gzip", gzipTag)
+	h.CheckEqual(t, resp.StatusCode, 304)
+	resp, _ = fetch(t, srv.URL+"/thing/"+name, "zstd", gzipTag)
+	h.CheckEqual(t, resp.StatusCode, 200)
+
+	// Below the threshold.
+	resp, b = fetch(t, srv.URL+"/thing/x", "gzip", "")
+	h.CheckEqual(t, resp.Header.Get("Content-Encoding"), "")
+	h.CheckEqual(t, string(b), "{\"Name\":\"x\"}\n")
+}
+
+func TestCompressedBody(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	put := func(encoding string, body []byte) int {
+		req, _ := http.NewRequest("PUT", srv.URL+"/thing/x", bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", encoding)
+		resp, err := http.DefaultClient.Do(req)
+		h.CheckEqual(t, err, nil)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	gzipped := func(s string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(s))
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	h.CheckEqual(t, put("gzip", gzipped(`{"Name": "x"}`)), 200)
+	truncated := gzipped(`{"Name": "x"}`)
+	h.CheckEqual(t, put("gzip", truncated[:len(truncated)-4]), 400)
+	h.CheckEqual(t, put("gzip", []byte(`{"Name": "x"}`)), 400)
+	h.CheckEqual(t, put("br", []byte(`{"Name": "x"}`)), 415)
+
+	// The body is closed once it is read, through the gzip reader.
+	body := &closeable{Reader: bytes.NewReader(gzipped(`{"Name": "x"}`))}
+	req := httptest.NewRequest("PUT", "/thing/x", body)
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	receiver.NewServer().Handler().ServeHTTP(w, req)
+	h.CheckEqual(t, w.Code, 200)
+	h.CheckEqual(t, body.closed, true)
+}
+
+// closeable is a request body that remembers if it was closed.
+type closeable struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeable) Close() error {
+	c.closed = true
+	return nil
+}
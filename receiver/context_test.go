@@ -0,0 +1,89 @@
+/*
+Gondul GO API, receiver context tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/log"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+// patient waits for as long as the context lets it on GET /patient/wait.
+type patient struct {
+	Element  string
+	Deadline bool
+}
+
+func (p *patient) Get(ctx context.Context, element string) (gondulapi.Report, error) {
+	p.Element = element
+	_, p.Deadline = ctx.Deadline()
+	if element == "wait" {
+		<-ctx.Done()
+		return gondulapi.Report{}, gondulapi.Errorf(503, "Gave up: %v", ctx.Err())
+	}
+	return gondulapi.Report{}, nil
+}
+
+func (p *patient) Post(ctx context.Context) (gondulapi.Report, error) {
+	if log.RequestID(ctx) == "" {
+		return gondulapi.Report{}, gondulapi.Errorf(500, "No request ID")
+	}
+	return gondulapi.Report{Ok: 1}, nil
+}
+
+func init() {
+	receiver.AddHandler("/patient/", func() interface{} { return &patient{} }, receiver.Timeout(50*time.Millisecond))
+}
+
+func TestContext(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	code, _, body := do(t, "GET", srv.URL+"/patient/now", "")
+	h.CheckEqual(t, code, 200)
+	h.CheckEqual(t, body, "{\"Element\":\"now\",\"Deadline\":true}\n")
+
+	start := time.Now()
+	code, _, body = do(t, "GET", srv.URL+"/patient/wait", "")
+	h.CheckEqual(t, code, 503)
+	h.CheckEqual(t, strings.Contains(body, "context deadline exceeded"), true)
+	h.CheckEqual(t, time.Since(start) >= 50*time.Millisecond, true)
+
+	req, _ := http.NewRequest("POST", srv.URL+"/patient/", strings.NewReader("{}"))
+	resp, err := http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	resp.Body.Close()
+	h.CheckEqual(t, resp.StatusCode, 200)
+
+	req, _ = http.NewRequest("DELETE", srv.URL+"/patient/x", nil)
+	resp, err = http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	resp.Body.Close()
+	h.CheckEqual(t, resp.StatusCode, 405)
+	h.CheckEqual(t, resp.Header.Get("Allow"), "GET, HEAD, POST, OPTIONS")
+}
@@ -0,0 +1,115 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gathering/gondulapi"
+)
+
+// Defaults for CORS, covering what the receiver itself uses.
+var (
+	corsHeaders = []string{"Authorization", "Content-Type", "If-Match", "If-None-Match", "X-Request-ID"}
+	corsExpose  = []string{"ETag", "Link", "X-Request-ID", "X-Total-Count"}
+)
+
+// CORS overrides gondulapi.Config.CORS for a single handler. The whole
+// configuration is replaced, so e.g. a handler can be opened to all
+// origins while the rest of the API isn't, or the other way around.
+func CORS(cors gondulapi.CORS) Option {
+	return func(rcvr *receiver) {
+		rcvr.cors = &cors
+	}
+}
+
+// allowed checks if origin is in list.
+func allowed(list []string, origin string) bool {
+	for _, candidate := range list {
+		if candidate == "*" || strings.EqualFold(candidate, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsHandler adds CORS headers to requests from allowed origins, and
+// answers preflights on its own. The methods allowed default to those
+// the object implements, same as the Allow header. Requests from other
+// origins are passed on untouched, leaving it to the browser to refuse
+// them.
+func (rcvr receiver) corsHandler(next http.Handler) http.Handler {
+	cors := gondulapi.Config.CORS
+	if rcvr.cors != nil {
+		cors = *rcvr.cors
+	}
+	if len(cors.Origins) == 0 {
+		return next
+	}
+	methods := cors.Methods
+	if len(methods) == 0 {
+		methods = findInterfaces(rcvr.alloc())
+	}
+	headers := cors.Headers
+	if len(headers) == 0 {
+		headers = corsHeaders
+	}
+	expose := cors.Expose
+	if len(expose) == 0 {
+		expose = corsExpose
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" || !allowed(cors.Origins, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// A wildcard can't be combined with credentials, so the
+		// origin is echoed instead.
+		if allowed(cors.Origins, "*") && !cors.Credentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if cors.Credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		method := r.Header.Get("Access-Control-Request-Method")
+		if r.Method != "OPTIONS" || method == "" {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(expose, ", "))
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		if allowed(methods, method) {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			if cors.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cors.MaxAge))
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,91 @@
+/*
+Gondul GO API, receiver CORS tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+func init() {
+	receiver.AddHandler("/corsy/", func() interface{} { return &thing{} }, receiver.CORS(gondulapi.CORS{
+		Origins:     []string{"https://gondul.example"},
+		Credentials: true,
+		MaxAge:      600,
+	}))
+}
+
+func cors(t *testing.T, method string, url string, origin string, request string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, nil)
+	req.Header.Set("Origin", origin)
+	if request != "" {
+		req.Header.Set("Access-Control-Request-Method", request)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	resp.Body.Close()
+	return resp
+}
+
+func TestCORS(t *testing.T) {
+	gondulapi.Config.CORS.Origins = []string{"*"}
+	defer func() { gondulapi.Config.CORS.Origins = nil }()
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	resp := cors(t, "OPTIONS", srv.URL+"/corsy/x", "https://gondul.example", "PUT")
+	h.CheckEqual(t, resp.StatusCode, 204)
+	h.CheckEqual(t, resp.Header.Get("Access-Control-Allow-Origin"), "https://gondul.example")
+	h.CheckEqual(t, resp.Header.Get("Access-Control-Allow-Credentials"), "true")
+	h.CheckEqual(t, resp.Header.Get("Access-Control-Allow-Methods"), "GET, HEAD, PUT, OPTIONS")
+	h.CheckEqual(t, resp.Header.Get("Access-Control-Allow-Headers"), "Authorization, Content-Type, If-Match, If-None-Match, X-Request-ID")
+	h.CheckEqual(t, resp.Header.Get("Access-Control-Max-Age"), "600")
+
+	// thing doesn't implement DELETE.
+	resp = cors(t, "OPTIONS", srv.URL+"/corsy/x", "https://gondul.example", "DELETE")
+	h.CheckEqual(t, resp.StatusCode, 204)
+	h.CheckEqual(t, resp.Header.Get("Access-Control-Allow-Methods"), "")
+
+	resp = cors(t, "GET", srv.URL+"/corsy/x", "https://gondul.example", "")
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, resp.Header.Get("Access-Control-Allow-Origin"), "https://gondul.example")
+	h.CheckEqual(t, resp.Header.Get("Access-Control-Expose-Headers"), "ETag, Link, X-Request-ID, X-Total-Count")
+
+	// The handler overrides the global wildcard.
+	resp = cors(t, "GET", srv.URL+"/corsy/x", "https://evil.example", "")
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, resp.Header.Get("Access-Control-Allow-Origin"), "")
+
+	resp = cors(t, "OPTIONS", srv.URL+"/thing/x", "https://evil.example", "PUT")
+	h.CheckEqual(t, resp.StatusCode, 204)
+	h.CheckEqual(t, resp.Header.Get("Access-Control-Allow-Origin"), "*")
+	h.CheckEqual(t, resp.Header.Get("Access-Control-Allow-Credentials"), "")
+
+	// A plain OPTIONS is not a preflight, and goes to the receiver.
+	resp = cors(t, "OPTIONS", srv.URL+"/thing/x", "https://evil.example", "")
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, resp.Header.Get("Allow"), "GET, HEAD, PUT, OPTIONS")
+}
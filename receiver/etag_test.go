@@ -0,0 +1,160 @@
+/*
+Gondul GO API, receiver conditional request tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+// versions is what versioned stores, by element.
+var versions = map[string]string{}
+
+// versioned is an object that changes, so it has more than one ETag.
+type versioned struct {
+	Version string
+}
+
+func (v *versioned) Get(element string) (gondulapi.Report, error) {
+	version, ok := versions[element]
+	if !ok {
+		return gondulapi.Report{}, gondulapi.Errorf(404, "No %s here", element)
+	}
+	v.Version = version
+	return gondulapi.Report{}, nil
+}
+
+func (v *versioned) Put(element string) (gondulapi.Report, error) {
+	versions[element] = v.Version
+	return gondulapi.Report{Ok: 1}, nil
+}
+
+func (v *versioned) Delete(element string) (gondulapi.Report, error) {
+	delete(versions, element)
+	return gondulapi.Report{Ok: 1}, nil
+}
+
+func init() {
+	receiver.AddHandler("/versioned/", func() interface{} { return &versioned{} })
+}
+
+// conditional sends a request with header set to value, and returns the
+// status code and the ETag of the reply.
+func conditional(t *testing.T, method string, url string, header string, value string, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	resp.Body.Close()
+	return resp.StatusCode, resp.Header.Get("ETag")
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+	url := srv.URL + "/versioned/a"
+	versions["a"] = "1"
+
+	code, tag := conditional(t, "GET", url, "", "", "")
+	h.CheckEqual(t, code, 200)
+	h.CheckNotEqual(t, tag, "")
+
+	cases := []struct {
+		value string
+		code  int
+	}{
+		{tag, 304},
+		{"W/" + tag, 304},
+		{"*", 304},
+		{`"other", ` + tag, 304},
+		{`"other"`, 200},
+		{`W/"other"`, 200},
+	}
+	for _, c := range cases {
+		code, _ = conditional(t, "GET", url, "If-None-Match", c.value, "")
+		h.CheckEqual(t, code, c.code)
+		code, _ = conditional(t, "HEAD", url, "If-None-Match", c.value, "")
+		h.CheckEqual(t, code, c.code)
+	}
+
+	// A new version gets a new ETag.
+	versions["a"] = "2"
+	code, newTag := conditional(t, "GET", url, "If-None-Match", tag, "")
+	h.CheckEqual(t, code, 200)
+	h.CheckNotEqual(t, newTag, tag)
+}
+
+func TestIfMatch(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+	url := srv.URL + "/versioned/b"
+	versions["b"] = "1"
+
+	_, tag := conditional(t, "GET", url, "", "", "")
+	gzipped := strings.TrimSuffix(tag, `"`) + `-gzip"`
+
+	cases := []struct {
+		header string
+		value  string
+		code   int
+	}{
+		{"If-Match", `"other"`, 412},
+		{"If-Match", "W/" + tag, 412},
+		{"If-Match", tag, 200},
+		{"If-Match", gzipped, 200},
+		{"If-Match", `"other", ` + tag, 200},
+		{"If-Match", "*", 200},
+		{"If-None-Match", "*", 412},
+		{"If-None-Match", "W/" + tag, 412},
+		{"If-None-Match", `"other"`, 200},
+	}
+	for _, c := range cases {
+		code, _ := conditional(t, "PUT", url, c.header, c.value, `{"Version": "1"}`)
+		h.CheckEqual(t, code, c.code)
+	}
+
+	// The ETag changes with the item, so the old one no longer matches.
+	code, _ := conditional(t, "PUT", url, "If-Match", tag, `{"Version": "2"}`)
+	h.CheckEqual(t, code, 200)
+	code, _ = conditional(t, "PUT", url, "If-Match", tag, `{"Version": "3"}`)
+	h.CheckEqual(t, code, 412)
+	h.CheckEqual(t, versions["b"], "2")
+	code, _ = conditional(t, "DELETE", url, "If-Match", tag, "")
+	h.CheckEqual(t, code, 412)
+
+	// Without an item, If-Match fails and If-None-Match: * is how to
+	// create it only if it doesn't exist.
+	code, _ = conditional(t, "PUT", srv.URL+"/versioned/c", "If-Match", "*", `{"Version": "1"}`)
+	h.CheckEqual(t, code, 412)
+	code, _ = conditional(t, "PUT", srv.URL+"/versioned/c", "If-None-Match", "*", `{"Version": "1"}`)
+	h.CheckEqual(t, code, 200)
+	code, _ = conditional(t, "PUT", srv.URL+"/versioned/c", "If-None-Match", "*", `{"Version": "2"}`)
+	h.CheckEqual(t, code, 412)
+	h.CheckEqual(t, versions["c"], "1")
+}
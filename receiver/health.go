@@ -0,0 +1,116 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gathering/gondulapi"
+	"github.com/gathering/gondulapi/log"
+)
+
+// Where liveness and readiness are served, outside of the prefix since
+// they are about the process and not the API.
+const (
+	healthPath = "/healthz"
+	readyPath  = "/readyz"
+)
+
+// checkTimeout is how long the readiness checks get, all together.
+const checkTimeout = 5 * time.Second
+
+// checkResult is the outcome of a single readiness check.
+type checkResult struct {
+	Status   string // "ok" or "fail"
+	Error    string `json:",omitempty"`
+	Duration string
+}
+
+// health is the reply from /healthz and /readyz.
+type health struct {
+	Status string                 // "ok" or "fail"
+	Checks map[string]checkResult `json:",omitempty"`
+}
+
+// runChecks runs checks in parallel, returning the result of each and
+// whether they all passed.
+func runChecks(ctx context.Context, checks map[string]gondulapi.Check) (map[string]checkResult, bool) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]checkResult, len(checks))
+	ok := true
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check gondulapi.Check) {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			res := checkResult{Status: "ok", Duration: time.Since(start).String()}
+			if err != nil {
+				log.Context(ctx).Printf("Readiness check %s failed: %v", name, err)
+				res.Status = "fail"
+				res.Error = err.Error()
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			results[name] = res
+			ok = ok && err == nil
+		}(name, check)
+	}
+	wg.Wait()
+	return results, ok
+}
+
+// healthHandler answers /healthz, which is always fine as long as the
+// process is able to answer. If ready is set, it answers /readyz instead,
+// running the readiness checks added with gondulapi.AddCheck and failing
+// while s is shutting down.
+func (s *Server) healthHandler(ready bool) http.Handler {
+	var rcvr receiver
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o := output{code: 200, data: health{Status: "ok"}, headers: map[string]string{"Cache-Control": "no-store"}}
+		if r.Method != "GET" && r.Method != "HEAD" {
+			o.code = 405
+			o.headers["Allow"] = "GET, HEAD"
+			o.data = gondulapi.Report{Error: gondulapi.Errorf(405, "%s on %s failed: No such method for this path", r.Method, r.URL.Path)}
+			rcvr.answer(w, r, o, false)
+			return
+		}
+		if ready {
+			results, ok := runChecks(r.Context(), gondulapi.Checks())
+			if s.draining.Load() {
+				results["shutdown"] = checkResult{Status: "fail", Error: "Shutting down", Duration: "0s"}
+				ok = false
+			}
+			h := health{Status: "ok", Checks: results}
+			if !ok {
+				h.Status = "fail"
+				o.code = 503
+			}
+			o.data = h
+		}
+		rcvr.answer(w, r, o, len(r.URL.Query()["pretty"]) > 0)
+	})
+}
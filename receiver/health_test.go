@@ -0,0 +1,106 @@
+/*
+Gondul GO API, receiver health tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+// healthy is what the "flaky" readiness check returns.
+var healthy error
+
+func init() {
+	gondulapi.AddCheck("flaky", func(ctx context.Context) error { return healthy })
+}
+
+// ready gets url and returns the status code, along with the overall
+// status and that of each check.
+func ready(t *testing.T, url string) (int, string, map[string]string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	h.CheckEqual(t, err, nil)
+	defer resp.Body.Close()
+	var body struct {
+		Status string
+		Checks map[string]struct{ Status string }
+	}
+	h.CheckEqual(t, json.NewDecoder(resp.Body).Decode(&body), nil)
+	checks := make(map[string]string)
+	for name, c := range body.Checks {
+		checks[name] = c.Status
+	}
+	return resp.StatusCode, body.Status, checks
+}
+
+func TestHealth(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	healthy = fmt.Errorf("not yet")
+	code, status, _ := ready(t, srv.URL+"/healthz")
+	h.CheckEqual(t, code, 200)
+	h.CheckEqual(t, status, "ok")
+	code, status, checks := ready(t, srv.URL+"/readyz")
+	h.CheckEqual(t, code, 503)
+	h.CheckEqual(t, status, "fail")
+	h.CheckEqual(t, checks["flaky"], "fail")
+
+	healthy = nil
+	code, status, checks = ready(t, srv.URL+"/readyz")
+	h.CheckEqual(t, code, 200)
+	h.CheckEqual(t, status, "ok")
+	h.CheckEqual(t, checks["flaky"], "ok")
+}
+
+func TestReadyDuringDrain(t *testing.T) {
+	healthy = nil
+	s := receiver.NewServer()
+	s.DrainDelay = 200 * time.Millisecond
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	h.CheckEqual(t, err, nil)
+	url := "http://" + l.Addr().String() + "/readyz"
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- s.Serve(ctx, l)
+	}()
+
+	code, _, _ := ready(t, url)
+	h.CheckEqual(t, code, 200)
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	code, status, checks := ready(t, url)
+	h.CheckEqual(t, code, 503)
+	h.CheckEqual(t, status, "fail")
+	h.CheckEqual(t, checks["shutdown"], "fail")
+	h.CheckEqual(t, checks["flaky"], "ok")
+	h.CheckEqual(t, <-served, nil)
+}
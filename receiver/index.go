@@ -0,0 +1,202 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gathering/gondulapi"
+	"github.com/gathering/gondulapi/log"
+)
+
+// Entry describes a registered handler in the API index.
+type Entry struct {
+	Path        string   // As registered, including the prefix and any parameters
+	Type        string   // Go type of the object, e.g. "*objects.Switch"
+	Methods     []string // Methods the object implements
+	Auth        string   // "none", "read-public", "private" or "custom"
+	AuthMethods []string `json:",omitempty"` // Methods that need credentials
+	Schema      string   // URL to send OPTIONS to for the JSON Schema of the object
+	OpenAPI     string   `json:",omitempty"` // Link to the schema in the OpenAPI document, if it is served
+}
+
+// Index is the reply from the root of the API, listing the handlers.
+type Index struct {
+	Handlers []Entry
+	OpenAPI  string `json:",omitempty"` // Path of the OpenAPI document, if it is served
+}
+
+// authKind sums up which of methods need credentials, in the terms of
+// the auth package: everything but OPTIONS is private, and read-public
+// leaves GET and HEAD to anyone.
+func authKind(methods []string, needed []string) string {
+	var reads, writes, neededReads, neededWrites int
+	for _, m := range methods {
+		if m == "OPTIONS" {
+			continue
+		}
+		read := m == "GET" || m == "HEAD"
+		if read {
+			reads++
+		} else {
+			writes++
+		}
+		if contains(needed, m) {
+			if read {
+				neededReads++
+			} else {
+				neededWrites++
+			}
+		}
+	}
+	switch {
+	case len(needed) == 0:
+		return "none"
+	case neededReads == reads && neededWrites == writes:
+		return "private"
+	case neededReads == 0 && neededWrites == writes:
+		return "read-public"
+	}
+	return "custom"
+}
+
+// contains checks if list has s.
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// apiIndex lists rcvrs, which must have their paths and patterns set and be
+// sorted, as done by mux. Schemas are linked in the OpenAPI document on
+// openAPIPath if it isn't blank, using the names in c.
+func apiIndex(rcvrs []receiver, c *components, openAPIPath string) Index {
+	idx := Index{Handlers: make([]Entry, 0, len(rcvrs)), OpenAPI: openAPIPath}
+	for _, rcvr := range rcvrs {
+		item := rcvr.alloc()
+		e := Entry{
+			Path:    rcvr.pattern.raw,
+			Type:    fmt.Sprintf("%T", item),
+			Methods: findInterfaces(item),
+			Schema:  rcvr.path,
+		}
+		for _, m := range e.Methods {
+			if m != "OPTIONS" && !public(item, rcvr.path, m) {
+				e.AuthMethods = append(e.AuthMethods, m)
+			}
+		}
+		e.Auth = authKind(e.Methods, e.AuthMethods)
+		if openAPIPath != "" {
+			t := reflect.Indirect(reflect.ValueOf(item)).Type()
+			e.OpenAPI = openAPIPath + "#/components/schemas/" + c.name(t)
+		}
+		idx.Handlers = append(idx.Handlers, e)
+	}
+	return idx
+}
+
+// indexPage is the index for humans.
+var indexPage = template.Must(template.New("index").Funcs(template.FuncMap{
+	"join": func(l []string) string { return strings.Join(l, ", ") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Gondul API</title></head>
+<body>
+<h1>Gondul API</h1>
+{{if .OpenAPI}}<p>OpenAPI document: <a href="{{.OpenAPI}}">{{.OpenAPI}}</a></p>{{end}}
+<table>
+<tr><th>Path</th><th>Type</th><th>Methods</th><th>Auth</th><th>Schema</th></tr>
+{{range .Handlers}}<tr>
+<td>{{if .Browsable}}<a href="{{.Path}}">{{.Path}}</a>{{else}}{{.Path}}{{end}}</td>
+<td><code>{{.Type}}</code></td>
+<td>{{join .Methods}}</td>
+<td>{{.Auth}}{{if .AuthMethods}} ({{join .AuthMethods}}){{end}}</td>
+<td>{{if .OpenAPI}}<a href="{{.OpenAPI}}">OpenAPI</a>{{else}}OPTIONS {{.Schema}}{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// browsable adds what the HTML page needs to know about an Entry.
+type browsable struct {
+	Entry
+	Browsable bool // Linked, since a browser can GET it as it is
+}
+
+// wantsHTML checks if the client asked for the index as HTML, with
+// ?format=html or by accepting text/html as browsers do.
+func wantsHTML(r *http.Request) bool {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f == "html"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// indexHandler serves idx on root, as JSON or any other registered
+// format, or as HTML for browsers. Anything below root that isn't handled
+// by something else is a 404.
+func indexHandler(root string, idx Index) http.Handler {
+	rows := make([]browsable, 0, len(idx.Handlers))
+	for _, e := range idx.Handlers {
+		rows = append(rows, browsable{e, contains(e.Methods, "GET") && !strings.Contains(e.Path, "{")})
+	}
+	var page bytes.Buffer
+	err := indexPage.Execute(&page, struct {
+		Index
+		Handlers []browsable
+	}{idx, rows})
+	if err != nil {
+		log.Printf("Unable to render the API index: %v", err)
+	}
+	var rcvr receiver
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pretty := len(r.URL.Query()["pretty"]) > 0
+		if r.URL.Path != root {
+			rcvr.answer(w, r, output{code: 404, data: gondulapi.Report{Error: gondulapi.Errorf(404, "Nothing at %s, see %s for what there is", r.URL.Path, root)}}, pretty)
+			return
+		}
+		if r.Method != "GET" && r.Method != "HEAD" {
+			rcvr.answer(w, r, output{code: 405, data: gondulapi.Report{Error: gondulapi.Errorf(405, "%s on %s failed: No such method for this path", r.Method, root)}, headers: map[string]string{"Allow": "GET, HEAD"}}, pretty)
+			return
+		}
+		if wantsHTML(r) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Add("Vary", "Accept")
+			if r.Method == "GET" {
+				w.Write(page.Bytes())
+			}
+			return
+		}
+		if _, err := negotiate(r); err != nil {
+			rcvr.answer(w, r, output{code: 400, data: gondulapi.Report{Error: err}}, pretty)
+			return
+		}
+		rcvr.answer(w, r, output{code: 200, data: idx}, pretty)
+	})
+}
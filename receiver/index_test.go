@@ -0,0 +1,81 @@
+/*
+Gondul GO API, receiver index tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+func TestIndex(t *testing.T) {
+	gondulapi.Config.HTTPUser, gondulapi.Config.HTTPPw = "admin", "secret"
+	defer func() { gondulapi.Config.HTTPUser, gondulapi.Config.HTTPPw = "", "" }()
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/")
+	h.CheckEqual(t, err, nil)
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, resp.Header.Get("Content-Type"), "application/json")
+	var idx receiver.Index
+	h.CheckEqual(t, json.NewDecoder(resp.Body).Decode(&idx), nil)
+	resp.Body.Close()
+
+	entries := make(map[string]receiver.Entry)
+	for _, e := range idx.Handlers {
+		entries[e.Path] = e
+	}
+	h.CheckEqual(t, entries["/thing/"].Type, "*receiver_test.thing")
+	h.CheckEqual(t, strings.Join(entries["/thing/"].Methods, " "), "GET HEAD PUT OPTIONS")
+	h.CheckEqual(t, entries["/thing/"].Auth, "none")
+	h.CheckEqual(t, entries["/private/"].Auth, "private")
+	h.CheckEqual(t, strings.Join(entries["/private/"].AuthMethods, " "), "GET HEAD")
+	h.CheckEqual(t, entries["/documented/{site}/box/{id:int}"].Schema, "/documented/")
+	h.CheckEqual(t, entries["/documented/{site}/box/{id:int}"].OpenAPI, "")
+
+	// The schema link works for pattern handlers too.
+	code, _, body := do(t, "OPTIONS", srv.URL+entries["/documented/{site}/box/{id:int}"].Schema, "")
+	h.CheckEqual(t, code, 200)
+	h.CheckEqual(t, strings.Contains(body, "\"Placement\""), true)
+
+	req, _ := http.NewRequest("GET", srv.URL+"/", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	resp, err = http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	page, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	h.CheckEqual(t, err, nil)
+	h.CheckEqual(t, resp.Header.Get("Content-Type"), "text/html; charset=utf-8")
+	h.CheckEqual(t, strings.Contains(string(page), `<a href="/thing/">/thing/</a>`), true)
+	h.CheckEqual(t, strings.Contains(string(page), "<code>*receiver_test.private</code>"), true)
+
+	code, _, _ = do(t, "GET", srv.URL+"/nothing/here", "")
+	h.CheckEqual(t, code, 404)
+	code, _, _ = do(t, "PUT", srv.URL+"/", "")
+	h.CheckEqual(t, code, 405)
+}
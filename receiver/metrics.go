@@ -0,0 +1,92 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gathering/gondulapi/metrics"
+)
+
+var (
+	requests = metrics.NewCounter("gondulapi_http_requests_total", "HTTP requests handled, by registered path, method and status code.", "path", "method", "code")
+	latency  = metrics.NewHistogram("gondulapi_http_request_duration_seconds", "Time spent handling HTTP requests, by registered path and method.", nil, "path", "method")
+)
+
+// methods are the methods counted by name. Anyone can send any method, so
+// the rest are counted as "other", to keep the number of label values
+// down.
+var methods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"PATCH":   true,
+	"DELETE":  true,
+	"OPTIONS": true,
+}
+
+// statusWriter remembers the status code written, for logging and
+// metrics.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the original writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// measure counts requests and their latency. The path is the registered
+// pattern, not the actual path, and unknown methods are "other", to keep
+// the number of label values down.
+func (rcvr receiver) measure(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		method := r.Method
+		if !methods[method] {
+			method = "other"
+		}
+		requests.Inc(rcvr.pattern.raw, method, strconv.Itoa(sw.code))
+		latency.Observe(time.Since(start).Seconds(), rcvr.pattern.raw, method)
+	})
+}
@@ -0,0 +1,203 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gathering/gondulapi"
+	"github.com/gathering/gondulapi/log"
+)
+
+// Middleware wraps the handling of a request, and is free to do whatever
+// it wants before and after calling the next handler, including not
+// calling it at all.
+//
+// Every request goes through the middleware in this order:
+//
+// 1. The built-in middleware, such as request IDs, request logging,
+// metrics, CORS and recovering from panics.
+//
+// 2. Global middleware added with Use, in the order added.
+//
+// 3. Middleware added to the handler with the With option to AddHandler,
+// in the order listed.
+//
+// 4. Rate limiting per remote address, see RateLimit.
+//
+// 5. Authentication, see gondulapi.Auther.
+//
+// 6. Rate limiting per user.
+//
+// 7. The receiver itself, reading the body and calling the object.
+//
+// So middleware sees every request, authenticated or not, and its
+// response, including authentication failures.
+type Middleware func(http.Handler) http.Handler
+
+// builtin is the middleware the receiver always uses, outermost first.
+var builtin = []Middleware{requestID, logRequests}
+
+// middleware is the global middleware added with Use.
+var middleware []Middleware
+
+// Use adds global middleware, used for all handlers. It only affects
+// servers created with NewServer after it is called.
+func Use(m ...Middleware) {
+	middleware = append(middleware, m...)
+}
+
+// With adds middleware to a single handler. It runs after the global
+// middleware, see Middleware.
+func With(m ...Middleware) Option {
+	return func(rcvr *receiver) {
+		rcvr.middleware = append(rcvr.middleware, m...)
+	}
+}
+
+// chain wraps h in the middleware, so the first one listed is the first
+// to see the request.
+func chain(h http.Handler, lists ...[]Middleware) http.Handler {
+	for i := len(lists) - 1; i >= 0; i-- {
+		for j := len(lists[i]) - 1; j >= 0; j-- {
+			h = lists[i][j](h)
+		}
+	}
+	return h
+}
+
+// handler returns the complete handler for rcvr, with all the middleware
+// in place.
+func (rcvr receiver) handler() http.Handler {
+	rcvr.limiters = rcvr.newLimiters()
+	if rcvr.perUser() {
+		rcvr.authMethods = rcvr.needsAuth()
+	}
+	return chain(rcvr.rateLimit(rcvr.authenticate(rcvr.userRateLimit(rcvr))), builtin, []Middleware{rcvr.measure, rcvr.corsHandler, rcvr.recoverPanics}, middleware, rcvr.middleware)
+}
+
+// requestIDHeader is the header a request ID is taken from and returned
+// in.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID checks that an incoming request ID is something we can
+// safely log and send back: short, and without spaces or control
+// characters.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID makes up a random request ID.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestID gives every request an ID, which is returned in the
+// X-Request-ID header and included in everything logged through
+// log.Context while serving it. An ID from a client or proxy in front of
+// us is used as is, so a request can be followed across services.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(log.WithRequestID(r.Context(), id)))
+	})
+}
+
+// logRequests logs every request.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Context(r.Context()).Printf("%s %s remote: %v", r.Method, r.URL, r.RemoteAddr)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// itemKey is the context key for the item allocated by authenticate.
+type itemKey struct{}
+
+// userKey is the context key for the user verified by authenticate.
+type userKey struct{}
+
+// authenticate allocates the item for the request and checks it with
+// checkAuth, before passing it on to next in the request context. The
+// same item is used for the rest of the request, so Auth can leave
+// something behind for the object. OPTIONS is public, since CORS
+// preflights never have credentials. The user verified by checkAuth is
+// passed on too, for rate limits per user, and failures are counted
+// against them, see failedAuth.
+func (rcvr receiver) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		item := rcvr.alloc()
+		ctx := context.WithValue(r.Context(), itemKey{}, item)
+		if r.Method != "OPTIONS" {
+			output, user, err := checkAuth(item, r, rcvr)
+			if err != nil {
+				log.Context(r.Context()).Printf("auth error: %s", err)
+				if rcvr.failedAuth(w, r) {
+					rcvr.answer(w, r, output, len(r.URL.Query()["pretty"]) > 0)
+				}
+				return
+			}
+			ctx = context.WithValue(ctx, userKey{}, user)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// recoverPanics turns a panic further down into a 500 with the usual JSON
+// error body, logging it with a stack trace. If the reply has already
+// started, there's nothing to do but log it and cut the reply short.
+func (rcvr receiver) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.Context(r.Context()).Printf("panic serving %s %s for %v: %v\n%s", r.Method, r.URL, r.RemoteAddr, err, debug.Stack())
+			if sw.code != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			rcvr.answer(w, r, output{code: 500, data: gondulapi.Report{Error: gondulapi.InternalError}}, false)
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
@@ -0,0 +1,97 @@
+/*
+Gondul GO API, receiver middleware tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+// trail records the order things happen in, through the X-Trail header.
+func trail(name string) receiver.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Trail") != "" {
+				r.Header.Set("X-Trail", r.Header.Get("X-Trail")+" "+name)
+				w.Header().Set("X-Trail", r.Header.Get("X-Trail"))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// guarded refuses anyone who gives a user name.
+type guarded struct {
+	Trail string
+}
+
+func (g *guarded) Auth(basepath string, element string, method string, user string, password string) error {
+	if user != "" {
+		return gondulapi.Errorf(401, "No users allowed")
+	}
+	return nil
+}
+
+func (g *guarded) Get(element string) (gondulapi.Report, error) {
+	g.Trail = element
+	return gondulapi.Report{}, nil
+}
+
+func init() {
+	receiver.Use(trail("global1"), trail("global2"))
+	receiver.AddHandler("/guarded/", func() interface{} { return &guarded{} }, receiver.With(trail("handler")))
+}
+
+func TestMiddleware(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/guarded/x", nil)
+	req.Header.Set("X-Trail", "start")
+	resp, err := http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	resp.Body.Close()
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, resp.Header.Get("X-Trail"), "start global1 global2 handler")
+
+	// Authentication comes after the middleware, so it sees failures too.
+	req, _ = http.NewRequest("GET", srv.URL+"/guarded/x", nil)
+	req.Header.Set("X-Trail", "start")
+	req.SetBasicAuth("someone", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	resp.Body.Close()
+	h.CheckEqual(t, resp.StatusCode, 401)
+	h.CheckEqual(t, resp.Header.Get("X-Trail"), "start global1 global2 handler")
+
+	// Other handlers only get the global middleware.
+	req, _ = http.NewRequest("GET", srv.URL+"/thing/x", nil)
+	req.Header.Set("X-Trail", "start")
+	resp, err = http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	resp.Body.Close()
+	h.CheckEqual(t, strings.HasSuffix(resp.Header.Get("X-Trail"), "global2"), true)
+}
@@ -0,0 +1,291 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gathering/gondulapi"
+	"github.com/gathering/gondulapi/log"
+)
+
+// openAPIVersion is the version of OpenAPI the document follows. It is
+// 3.0 and not 3.1, since schema uses "nullable".
+const openAPIVersion = "3.0.3"
+
+// ref returns a reference to the schema called name in the components.
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// content returns the content of a request or response body of the given
+// media types, all with the same schema.
+func content(schema map[string]interface{}, mediaTypes ...string) map[string]interface{} {
+	c := make(map[string]interface{})
+	for _, mt := range mediaTypes {
+		c[mt] = map[string]interface{}{"schema": schema}
+	}
+	return c
+}
+
+// components collects the schemas of the objects, named after their Go
+// types. Types with the same name from different packages get a number.
+type components struct {
+	schemas map[string]interface{}
+	names   map[reflect.Type]string
+}
+
+// name returns the component name of t, adding its schema the first time.
+func (c *components) name(t reflect.Type) string {
+	if name, ok := c.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if name == "" {
+		name = "Object"
+	}
+	for i := 2; c.schemas[name] != nil; i++ {
+		name = fmt.Sprintf("%s%d", t.Name(), i)
+	}
+	c.names[t] = name
+	c.schemas[name] = schema(t)
+	return name
+}
+
+// public checks if item lets anonymous clients use method, by asking its
+// Auther the same way checkAuth does.
+func public(item interface{}, path string, method string) bool {
+	switch auth := item.(type) {
+	case gondulapi.CredentialAuther:
+		return auth.Auth(path, "", method, gondulapi.Credentials{}) == nil
+	case gondulapi.Auther:
+		return auth.Auth(path, "", method, "", "") == nil
+	}
+	return true
+}
+
+// operations describes what rcvr does for each method, as OpenAPI
+// operations for the path of an element and the path of the handler
+// itself, which is where POST goes.
+func (rcvr receiver) operations(c *components) (element map[string]interface{}, base map[string]interface{}) {
+	item := rcvr.alloc()
+	t := reflect.Indirect(reflect.ValueOf(item)).Type()
+	object := ref(c.name(t))
+	report := map[string]interface{}{
+		"description": "What was done",
+		"content":     content(ref("Report"), "application/json"),
+	}
+	body := map[string]interface{}{
+		"required": true,
+		"content":  content(object, "application/json", "application/yaml", "application/msgpack"),
+	}
+	var params []interface{}
+	for _, seg := range rcvr.pattern.segments {
+		if seg.name == "" {
+			continue
+		}
+		kind := "string"
+		if seg.kind == "int" {
+			kind = "integer"
+		}
+		params = append(params, map[string]interface{}{
+			"name":     seg.name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": kind},
+		})
+	}
+	if len(rcvr.pattern.segments) == 0 && strings.HasSuffix(rcvr.path, "/") {
+		params = append(params, map[string]interface{}{
+			"name":     "element",
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+
+	element = make(map[string]interface{})
+	base = make(map[string]interface{})
+	for _, method := range findInterfaces(item) {
+		op := map[string]interface{}{
+			"tags":      []string{c.name(t)},
+			"responses": map[string]interface{}{"default": map[string]interface{}{"$ref": "#/components/responses/Error"}},
+		}
+		responses := op["responses"].(map[string]interface{})
+		switch method {
+		case "GET":
+			op["summary"] = "Get " + c.name(t)
+			responses["200"] = map[string]interface{}{
+				"description": c.name(t),
+				"content":     content(object, "application/json", "application/yaml", "application/msgpack", "text/csv"),
+			}
+			responses["304"] = map[string]interface{}{"description": "Not modified, see If-None-Match"}
+		case "HEAD":
+			op["summary"] = "Get " + c.name(t) + ", without the body"
+			responses["200"] = map[string]interface{}{"description": c.name(t)}
+		case "PUT":
+			op["summary"] = "Replace " + c.name(t)
+			op["requestBody"] = body
+			responses["200"] = report
+		case "POST":
+			op["summary"] = "Add " + c.name(t)
+			op["requestBody"] = body
+			responses["200"] = report
+		case "PATCH":
+			op["summary"] = "Update parts of " + c.name(t)
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					mergePatchType: map[string]interface{}{"schema": object},
+					jsonPatchType:  map[string]interface{}{"schema": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}}},
+				},
+			}
+			responses["200"] = report
+		case "DELETE":
+			op["summary"] = "Delete " + c.name(t)
+			responses["200"] = report
+		default:
+			continue
+		}
+		if !public(item, rcvr.path, method) {
+			op["security"] = []interface{}{map[string]interface{}{"basicAuth": []string{}}}
+		}
+		if method == "POST" {
+			base[strings.ToLower(method)] = op
+			continue
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		element[strings.ToLower(method)] = op
+	}
+	return element, base
+}
+
+// elementPath returns the path of an element of rcvr in OpenAPI syntax,
+// with the types left out of the parameters.
+func (rcvr receiver) elementPath() string {
+	if len(rcvr.pattern.segments) == 0 {
+		if strings.HasSuffix(rcvr.path, "/") {
+			return rcvr.path + "{element}"
+		}
+		return rcvr.path
+	}
+	parts := make([]string, 0, len(rcvr.pattern.segments))
+	for _, seg := range rcvr.pattern.segments {
+		if seg.name != "" {
+			parts = append(parts, "{"+seg.name+"}")
+		} else {
+			parts = append(parts, seg.literal)
+		}
+	}
+	return rcvr.path + strings.Join(parts, "/")
+}
+
+// newComponents returns components holding the Report returned by
+// writes, and the Problem returned on errors.
+func newComponents() *components {
+	c := &components{schemas: make(map[string]interface{}), names: make(map[reflect.Type]string)}
+	report := schema(reflect.TypeOf(gondulapi.Report{}))
+	// Errors are sent as a Problem instead.
+	delete(report["properties"].(map[string]interface{}), "Error")
+	c.names[reflect.TypeOf(gondulapi.Report{})] = "Report"
+	c.schemas["Report"] = report
+	c.name(reflect.TypeOf(gondulapi.Problem{}))
+	return c
+}
+
+// openAPI returns the OpenAPI document describing rcvrs, which must have
+// their paths and patterns set and be sorted, as done by mux. The schemas
+// of the objects are added to c.
+func openAPI(rcvrs []receiver, c *components) map[string]interface{} {
+	paths := make(map[string]interface{})
+	add := func(path string, ops map[string]interface{}) {
+		if len(ops) == 0 {
+			return
+		}
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		for method, op := range ops {
+			item[method] = op
+		}
+	}
+	for _, rcvr := range rcvrs {
+		element, base := rcvr.operations(c)
+		add(rcvr.elementPath(), element)
+		add(rcvr.path, base)
+	}
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "Gondul API",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": c.schemas,
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "The request failed, see RFC 7807",
+					"content":     content(ref("Problem"), problemType),
+				},
+			},
+			"securitySchemes": map[string]interface{}{
+				"basicAuth": map[string]interface{}{"type": "http", "scheme": "basic"},
+			},
+		},
+	}
+}
+
+// openAPIHandler serves doc as JSON, or as YAML if the client prefers it.
+// The document is marshalled once, since the handlers don't change after
+// the server is created.
+func openAPIHandler(doc map[string]interface{}) http.Handler {
+	encoded := make(map[string][]byte)
+	for _, f := range formats {
+		if f.name != "json" && f.name != "yaml" {
+			continue
+		}
+		b, err := f.codec.Marshal(doc)
+		if err != nil {
+			log.Printf("Unable to marshal the OpenAPI document as %s: %v", f.name, err)
+			continue
+		}
+		encoded[f.mediaTypes[0]] = b
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, err := negotiate(r)
+		b, ok := encoded[f.mediaTypes[0]]
+		if err != nil || !ok {
+			f = formats[0]
+			b = encoded[f.mediaTypes[0]]
+		}
+		w.Header().Set("Content-Type", f.mediaTypes[0])
+		w.Header().Add("Vary", "Accept")
+		w.Write(b)
+	})
+}
@@ -0,0 +1,126 @@
+/*
+Gondul GO API, receiver OpenAPI tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+	"github.com/gathering/gondulapi/types"
+)
+
+// documented has one of each kind of field the OpenAPI document cares
+// about.
+type documented struct {
+	Name      string
+	Addr      *types.IP `column:"addr"`
+	Placement types.Box
+	Tags      *types.Jsonb
+	Hidden    string `json:"-"`
+	Renamed   int    `json:"renamed"`
+}
+
+func (d *documented) Get(request *gondulapi.Request) (gondulapi.Report, error) {
+	return gondulapi.Report{}, nil
+}
+
+func (d documented) Post() (gondulapi.Report, error) {
+	return gondulapi.Report{}, nil
+}
+
+func init() {
+	receiver.AddHandler("/documented/{site}/box/{id:int}", func() interface{} { return &documented{} })
+}
+
+// node returns what's at path in the decoded JSON document doc, or nil.
+func node(doc interface{}, path ...string) interface{} {
+	for _, p := range path {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		doc = m[p]
+	}
+	return doc
+}
+
+func TestOpenAPI(t *testing.T) {
+	gondulapi.Config.OpenAPIPath = "/openapi.json"
+	gondulapi.Config.HTTPUser, gondulapi.Config.HTTPPw = "admin", "secret"
+	defer func() {
+		gondulapi.Config.OpenAPIPath = ""
+		gondulapi.Config.HTTPUser, gondulapi.Config.HTTPPw = "", ""
+	}()
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/openapi.json")
+	h.CheckEqual(t, err, nil)
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, resp.Header.Get("Content-Type"), "application/json")
+	var doc map[string]interface{}
+	h.CheckEqual(t, json.NewDecoder(resp.Body).Decode(&doc), nil)
+	resp.Body.Close()
+
+	h.CheckEqual(t, doc["openapi"], "3.0.3")
+	h.CheckNotEqual(t, node(doc, "paths", "/thing/{element}", "get"), nil)
+	h.CheckNotEqual(t, node(doc, "paths", "/thing/{element}", "put", "requestBody"), nil)
+	h.CheckEqual(t, node(doc, "paths", "/thing/{element}", "post"), nil)
+	h.CheckEqual(t, node(doc, "paths", "/private/{element}", "get", "security") != nil, true)
+	h.CheckEqual(t, node(doc, "paths", "/thing/{element}", "get", "security"), nil)
+
+	params, _ := node(doc, "paths", "/documented/{site}/box/{id}", "get", "parameters").([]interface{})
+	h.CheckEqual(t, len(params), 2)
+	h.CheckEqual(t, node(params[1], "name"), "id")
+	h.CheckEqual(t, node(params[1], "schema", "type"), "integer")
+	h.CheckNotEqual(t, node(doc, "paths", "/documented/", "post"), nil)
+
+	props := node(doc, "components", "schemas", "documented", "properties")
+	h.CheckEqual(t, node(props, "Name", "type"), "string")
+	h.CheckEqual(t, node(props, "Name", "nullable"), nil)
+	h.CheckEqual(t, node(props, "Addr", "format"), "ip")
+	h.CheckEqual(t, node(props, "Addr", "nullable"), true)
+	h.CheckEqual(t, node(props, "Placement", "type"), "object")
+	h.CheckEqual(t, node(props, "Placement", "properties", "X1", "type"), "integer")
+	h.CheckEqual(t, node(props, "Tags", "type"), nil)
+	h.CheckEqual(t, node(props, "Tags", "nullable"), true)
+	h.CheckEqual(t, node(props, "Hidden"), nil)
+	h.CheckEqual(t, node(props, "renamed", "type"), "integer")
+
+	h.CheckEqual(t, node(doc, "components", "schemas", "Report", "properties", "Error"), nil)
+	h.CheckEqual(t, node(doc, "components", "schemas", "Problem", "properties", "status", "type"), "integer")
+	h.CheckEqual(t, node(doc, "components", "schemas", "Problem", "properties", "errors", "items", "properties", "field", "type"), "string")
+	h.CheckEqual(t, node(doc, "components", "responses", "Error", "content", "application/problem+json", "schema", "$ref"), "#/components/schemas/Problem")
+	h.CheckEqual(t, node(doc, "paths", "/thing/{element}", "put", "responses", "default", "$ref"), "#/components/responses/Error")
+
+	req, _ := http.NewRequest("GET", srv.URL+"/openapi.json", nil)
+	req.Header.Set("Accept", "application/yaml")
+	resp, err = http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	resp.Body.Close()
+	h.CheckEqual(t, resp.Header.Get("Content-Type"), "application/yaml")
+	h.CheckEqual(t, strings.Contains(resp.Header.Get("Vary"), "Accept"), true)
+}
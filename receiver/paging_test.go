@@ -0,0 +1,108 @@
+/*
+Gondul GO API, receiver paging tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+// paged pretends to be a page of a collection, with the neighbouring
+// pages given by the element.
+type paged []string
+
+func (p *paged) Get(element string) (gondulapi.Report, error) {
+	*p = paged{"a", "b"}
+	page := gondulapi.Page{}
+	switch element {
+	case "both":
+		page = gondulapi.Page{Next: "cursor=next", Prev: "cursor=prev"}
+	case "next":
+		page = gondulapi.Page{Next: "offset=4"}
+	case "prev":
+		page = gondulapi.Page{Prev: "offset=0"}
+	}
+	return gondulapi.Report{Page: &page}, nil
+}
+
+func init() {
+	receiver.AddHandler("/paged/", func() interface{} { return &paged{} })
+}
+
+func TestLinks(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	cases := []struct {
+		path string
+		link string
+	}{
+		{"/paged/both", `</paged/both?cursor=next>; rel="next", </paged/both?cursor=prev>; rel="prev"`},
+		{"/paged/next?limit=2", `</paged/next?limit=2&offset=4>; rel="next"`},
+		{"/paged/prev?limit=2&offset=2", `</paged/prev?limit=2&offset=0>; rel="prev"`},
+		// The old position is replaced, the rest is kept as it was
+		// sent, with < and > escaped since they delimit the link.
+		{"/paged/both?cursor=old&vlan>=10&sysname=e1%2D1&pretty", `</paged/both?vlan%3E=10&sysname=e1%2D1&pretty&cursor=next>; rel="next", </paged/both?vlan%3E=10&sysname=e1%2D1&pretty&cursor=prev>; rel="prev"`},
+		{"/paged/next?offset=2&cursorless=1", `</paged/next?cursorless=1&offset=4>; rel="next"`},
+		{"/paged/none?limit=2", ""},
+	}
+	for _, c := range cases {
+		resp, err := http.Get(srv.URL + c.path)
+		h.CheckEqual(t, err, nil)
+		resp.Body.Close()
+		h.CheckEqual(t, resp.StatusCode, 200)
+		h.CheckEqual(t, resp.Header.Get("Link"), c.link)
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	defer func() { gondulapi.Config.PageSize, gondulapi.Config.MaxPageSize = 0, 0 }()
+	cases := []struct {
+		def   int
+		max   int
+		query string
+		limit int
+	}{
+		// Without page sizes, everything is fetched unless asked not to.
+		{0, 0, "", 0},
+		{0, 0, "limit=5000", 5000},
+		{0, 100, "", 100},
+		{0, 100, "limit=5000", 100},
+		{10, 100, "", 10},
+		{10, 0, "limit=50", 50},
+	}
+	for _, c := range cases {
+		gondulapi.Config.PageSize, gondulapi.Config.MaxPageSize = c.def, c.max
+		srv := httptest.NewServer(receiver.NewServer().Handler())
+		resp, err := http.Get(srv.URL + "/queried/?" + c.query)
+		h.CheckEqual(t, err, nil)
+		var q queried
+		h.CheckEqual(t, json.NewDecoder(resp.Body).Decode(&q), nil)
+		resp.Body.Close()
+		srv.Close()
+		h.CheckEqual(t, q.Limit, c.limit)
+	}
+}
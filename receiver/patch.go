@@ -0,0 +1,390 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gathering/gondulapi"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// decode is json.Unmarshal to a generic document, but keeps numbers as
+// json.Number so they survive the round trip untouched.
+func decode(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// patch does the receiver's part of PATCH: it fetches the current version
+// of the object into a freshly allocated one, applies the patch to its
+// JSON representation, decodes the result onto item and hands it to the
+// Patcher along with the list of fields that changed.
+func (rcvr receiver) patch(item interface{}, input input, request *gondulapi.Request) (gondulapi.Report, error) {
+	var report gondulapi.Report
+	current := rcvr.alloc()
+	_, isget, err := callGet(current, request)
+	if !isget {
+		return report, gondulapi.Errorf(405, "PATCH on %s needs GET to fetch what to patch", rcvr.path)
+	}
+	if err != nil {
+		return report, err
+	}
+	b, err := json.Marshal(current)
+	if err != nil {
+		return report, err
+	}
+	var doc interface{}
+	if err := decode(b, &doc); err != nil {
+		return report, err
+	}
+	old, ok := doc.(map[string]interface{})
+	if !ok {
+		return report, gondulapi.Errorf(405, "PATCH on %s only works for objects", rcvr.path)
+	}
+	// The patch works on a copy, so old is kept for the comparison.
+	if err := decode(b, &doc); err != nil {
+		return report, err
+	}
+
+	mediatype, _, _ := mime.ParseMediaType(input.contentType)
+	switch mediatype {
+	case mergePatchType:
+		if input.strict {
+			if err := strict(input.data, item); err != nil {
+				return report, err
+			}
+		}
+		var p interface{}
+		if err := decode(input.data, &p); err != nil {
+			return report, gondulapi.Errorf(400, "Invalid merge patch: %v", err)
+		}
+		doc = mergePatch(doc, p)
+	case jsonPatchType:
+		var ops []operation
+		if input.strict {
+			if err := strict(input.data, &ops); err != nil {
+				return report, err
+			}
+		}
+		if err := json.Unmarshal(input.data, &ops); err != nil {
+			return report, gondulapi.Errorf(400, "Invalid JSON patch: %v", err)
+		}
+		doc, err = jsonPatch(doc, ops)
+		if err != nil {
+			return report, err
+		}
+	default:
+		return report, gondulapi.Errorf(415, "PATCH needs Content-Type %s or %s, got %q", mergePatchType, jsonPatchType, input.contentType)
+	}
+
+	patched, ok := doc.(map[string]interface{})
+	if !ok {
+		return report, gondulapi.Errorf(422, "The patch must leave an object, not replace it")
+	}
+	fields := make([]string, 0)
+	for k := range old {
+		if _, ok := patched[k]; !ok {
+			patched[k] = nil
+		}
+	}
+	for k, v := range patched {
+		if !reflect.DeepEqual(old[k], v) {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	b, err = json.Marshal(patched)
+	if err != nil {
+		return report, err
+	}
+	// A JSON patch can add fields the object doesn't have, which is only
+	// seen here. The offsets are in b, so they are left out.
+	if input.strict {
+		if err := strict(b, item); err != nil {
+			return report, withoutOffsets(err)
+		}
+	}
+	if err := json.Unmarshal(b, &item); err != nil {
+		return report, gondulapi.Errorf(422, "The patched object is invalid: %v", err)
+	}
+	if err := validate(item, "PATCH"); err != nil {
+		return report, err
+	}
+
+	switch p := item.(type) {
+	case gondulapi.RequestPatcher:
+		return p.Patch(request, fields)
+	case gondulapi.ContextPatcher:
+		return p.Patch(request.Context, request.Element, fields)
+	case gondulapi.Patcher:
+		return p.Patch(request.Element, fields)
+	}
+	return report, gondulapi.InternalError
+}
+
+// mergePatch applies a JSON Merge Patch, as described by RFC 7396, to
+// target, returning the result.
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// operation is a single JSON Patch operation. Value is kept raw, since a
+// null value is different from no value.
+type operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// jsonPatch applies a JSON Patch, as described by RFC 6902, to doc,
+// returning the result. A failing test is a 409, anything else that
+// can't be applied is a 422.
+func jsonPatch(doc interface{}, ops []operation) (interface{}, error) {
+	for idx, op := range ops {
+		path, err := pointer(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, gondulapi.Errorf(400, "Invalid JSON patch, operation %d (%s) has no value", idx, op.Op)
+			}
+			if err := decode(op.Value, &value); err != nil {
+				return nil, gondulapi.Errorf(400, "Invalid JSON patch, operation %d has an invalid value: %v", idx, err)
+			}
+		case "move", "copy":
+			from, err := pointer(op.From)
+			if err != nil {
+				return nil, err
+			}
+			if op.Op == "move" && strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				return nil, gondulapi.Errorf(422, "Can't move %s into itself", op.From)
+			}
+			value, err = jsonGet(doc, from)
+			if err != nil {
+				return nil, err
+			}
+			if op.Op == "move" {
+				doc, err = jsonRemove(doc, from)
+			} else {
+				value, err = deepCopy(value)
+			}
+			if err != nil {
+				return nil, err
+			}
+		case "remove":
+		default:
+			return nil, gondulapi.Errorf(400, "Invalid JSON patch, unknown operation %q", op.Op)
+		}
+
+		switch op.Op {
+		case "add", "move", "copy":
+			doc, err = jsonAdd(doc, path, value)
+		case "remove":
+			doc, err = jsonRemove(doc, path)
+		case "replace":
+			if len(path) == 0 {
+				doc = value
+			} else if _, err = jsonGet(doc, path); err == nil {
+				if doc, err = jsonRemove(doc, path); err == nil {
+					doc, err = jsonAdd(doc, path, value)
+				}
+			}
+		case "test":
+			var found interface{}
+			found, err = jsonGet(doc, path)
+			if err == nil && !reflect.DeepEqual(found, value) {
+				err = gondulapi.Errorf(409, "JSON patch test failed for %s", op.Path)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// pointer splits a JSON Pointer (RFC 6901) into unescaped tokens.
+func pointer(path string) ([]string, error) {
+	if path == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, gondulapi.Errorf(400, "Invalid JSON pointer %q", path)
+	}
+	tokens := strings.Split(path[1:], "/")
+	for idx := range tokens {
+		tokens[idx] = strings.NewReplacer("~1", "/", "~0", "~").Replace(tokens[idx])
+	}
+	return tokens, nil
+}
+
+// index parses token as an index into an array of length n. If end is
+// true, n itself (or "-") is also accepted, for adding at the end.
+func index(token string, n int, end bool) (int, error) {
+	if token == "-" && end {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > n || (i == n && !end) || (len(token) > 1 && token[0] == '0') {
+		return 0, gondulapi.Errorf(422, "Invalid array index %q", token)
+	}
+	return i, nil
+}
+
+// modify walks doc to the parent of the last token, and replaces it with
+// what fn returns. fn gets the parent and the last token.
+func modify(doc interface{}, tokens []string, fn func(parent interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+	var err error
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		child, ok := c[tokens[0]]
+		if !ok {
+			return nil, gondulapi.Errorf(422, "No such path: %s", tokens[0])
+		}
+		c[tokens[0]], err = modify(child, tokens[1:], fn)
+		return c, err
+	case []interface{}:
+		i, err := index(tokens[0], len(c), false)
+		if err != nil {
+			return nil, err
+		}
+		c[i], err = modify(c[i], tokens[1:], fn)
+		return c, err
+	}
+	return nil, gondulapi.Errorf(422, "No such path: %s", tokens[0])
+}
+
+// jsonGet returns the value found at path in doc.
+func jsonGet(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch c := doc.(type) {
+		case map[string]interface{}:
+			child, ok := c[token]
+			if !ok {
+				return nil, gondulapi.Errorf(422, "No such path: %s", token)
+			}
+			doc = child
+		case []interface{}:
+			i, err := index(token, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, gondulapi.Errorf(422, "No such path: %s", token)
+		}
+	}
+	return doc, nil
+}
+
+// jsonAdd adds value at path in doc, inserting it if the parent is an
+// array.
+func jsonAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return modify(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			c[key] = value
+			return c, nil
+		case []interface{}:
+			i, err := index(key, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		}
+		return nil, gondulapi.Errorf(422, "Can't add %s to a non-container", key)
+	})
+}
+
+// jsonRemove removes the value at path in doc.
+func jsonRemove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, gondulapi.Errorf(422, "Can't remove the entire document")
+	}
+	return modify(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := c[key]; !ok {
+				return nil, gondulapi.Errorf(422, "No such path: %s", key)
+			}
+			delete(c, key)
+			return c, nil
+		case []interface{}:
+			i, err := index(key, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		}
+		return nil, gondulapi.Errorf(422, "No such path: %s", key)
+	})
+}
+
+// deepCopy copies a generic document, so copy doesn't leave two
+// references to the same map or slice.
+func deepCopy(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var c interface{}
+	err = decode(b, &c)
+	return c, err
+}
@@ -0,0 +1,141 @@
+/*
+Gondul GO API, receiver PATCH tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+// patched is what PATCH is tested on, with stored as what it is patched
+// from and to, and changed as the fields the last Patch got.
+type patched struct {
+	Name  string
+	Tags  []string
+	Attrs map[string]interface{} `json:"attrs"`
+	Note  *string
+}
+
+var (
+	stored  patched
+	changed []string
+)
+
+func (p *patched) Get(element string) (gondulapi.Report, error) {
+	*p = stored
+	return gondulapi.Report{}, nil
+}
+
+func (p *patched) Patch(element string, fields []string) (gondulapi.Report, error) {
+	stored = *p
+	changed = fields
+	return gondulapi.Report{Ok: 1}, nil
+}
+
+func init() {
+	receiver.AddHandler("/patched/", func() interface{} { return &patched{} })
+}
+
+// summary is stored as one string, to compare with what is expected.
+func summary() string {
+	note := "<nil>"
+	if stored.Note != nil {
+		note = *stored.Note
+	}
+	return fmt.Sprintf("%s %s %v %s", stored.Name, strings.Join(stored.Tags, ","), stored.Attrs, note)
+}
+
+func TestPatch(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	const (
+		mergeType = "application/merge-patch+json"
+		patchType = "application/json-patch+json"
+	)
+	original := "e1 a,b map[a/b:1 m~n:2] <nil>"
+	cases := []struct {
+		contentType string
+		patch       string
+		code        int
+		summary     string
+		changed     string
+	}{
+		{mergeType, `{"Name": "e2"}`, 200, "e2 a,b map[a/b:1 m~n:2] <nil>", "Name"},
+		{mergeType, `{"Note": "hi", "attrs": {"a/b": null, "x": 3}}`, 200, "e1 a,b map[m~n:2 x:3] hi", "Note|attrs"},
+		{mergeType, `{"Tags": ["c"]}`, 200, "e1 c map[a/b:1 m~n:2] <nil>", "Tags"},
+		{mergeType, `{"Tags": null}`, 200, "e1  map[a/b:1 m~n:2] <nil>", "Tags"},
+		{mergeType, `{"Name": "e1"}`, 200, original, ""},
+		{patchType, `[{"op": "add", "path": "/Tags/-", "value": "c"}]`, 200, "e1 a,b,c map[a/b:1 m~n:2] <nil>", "Tags"},
+		{patchType, `[{"op": "add", "path": "/Tags/0", "value": "z"}]`, 200, "e1 z,a,b map[a/b:1 m~n:2] <nil>", "Tags"},
+		{patchType, `[{"op": "add", "path": "/Tags/2", "value": "c"}]`, 200, "e1 a,b,c map[a/b:1 m~n:2] <nil>", "Tags"},
+		{patchType, `[{"op": "add", "path": "/attrs/x", "value": 3}]`, 200, "e1 a,b map[a/b:1 m~n:2 x:3] <nil>", "attrs"},
+		{patchType, `[{"op": "add", "path": "/Note", "value": "hi"}]`, 200, "e1 a,b map[a/b:1 m~n:2] hi", "Note"},
+		{patchType, `[{"op": "remove", "path": "/Tags/0"}]`, 200, "e1 b map[a/b:1 m~n:2] <nil>", "Tags"},
+		{patchType, `[{"op": "remove", "path": "/attrs/a~1b"}]`, 200, "e1 a,b map[m~n:2] <nil>", "attrs"},
+		{patchType, `[{"op": "remove", "path": "/Name"}]`, 200, " a,b map[a/b:1 m~n:2] <nil>", "Name"},
+		{patchType, `[{"op": "replace", "path": "/Name", "value": "e2"}]`, 200, "e2 a,b map[a/b:1 m~n:2] <nil>", "Name"},
+		{patchType, `[{"op": "replace", "path": "/attrs/m~0n", "value": 5}]`, 200, "e1 a,b map[a/b:1 m~n:5] <nil>", "attrs"},
+		{patchType, `[{"op": "move", "from": "/Tags/0", "path": "/Tags/-"}]`, 200, "e1 b,a map[a/b:1 m~n:2] <nil>", "Tags"},
+		{patchType, `[{"op": "move", "from": "/attrs/a~1b", "path": "/attrs/c"}]`, 200, "e1 a,b map[c:1 m~n:2] <nil>", "attrs"},
+		{patchType, `[{"op": "copy", "from": "/Name", "path": "/Note"}]`, 200, "e1 a,b map[a/b:1 m~n:2] e1", "Note"},
+		{patchType, `[{"op": "copy", "from": "/Tags/1", "path": "/Tags/0"}]`, 200, "e1 b,a,b map[a/b:1 m~n:2] <nil>", "Tags"},
+		{patchType, `[{"op": "test", "path": "/Name", "value": "e1"}, {"op": "replace", "path": "/Name", "value": "e2"}]`, 200, "e2 a,b map[a/b:1 m~n:2] <nil>", "Name"},
+		{patchType, `[{"op": "test", "path": "/Tags", "value": ["a", "b"]}]`, 200, original, ""},
+		{patchType, `[]`, 200, original, ""},
+		// A failed test is a conflict, and nothing is changed.
+		{patchType, `[{"op": "replace", "path": "/Name", "value": "e2"}, {"op": "test", "path": "/Name", "value": "e1"}]`, 409, original, ""},
+		{patchType, `[{"op": "test", "path": "/attrs/a~1b", "value": 2}]`, 409, original, ""},
+		// Patches that make no sense are 400.
+		{mergeType, `{"Name": `, 400, original, ""},
+		{patchType, `{"op": "add"}`, 400, original, ""},
+		{patchType, `[{"op": "frob", "path": "/Name"}]`, 400, original, ""},
+		{patchType, `[{"op": "add", "path": "/Name"}]`, 400, original, ""},
+		{patchType, `[{"op": "replace", "path": "Name", "value": "e2"}]`, 400, original, ""},
+		{patchType, `[{"op": "copy", "from": "Name", "path": "/Note"}]`, 400, original, ""},
+		// Patches that make sense, but not for this object, are 422.
+		{patchType, `[{"op": "remove", "path": "/Nope"}]`, 422, original, ""},
+		{patchType, `[{"op": "replace", "path": "/Nope", "value": 1}]`, 422, original, ""},
+		{patchType, `[{"op": "add", "path": "/Nope/x", "value": 1}]`, 422, original, ""},
+		{patchType, `[{"op": "add", "path": "/Tags/3", "value": "c"}]`, 422, original, ""},
+		{patchType, `[{"op": "add", "path": "/Tags/01", "value": "c"}]`, 422, original, ""},
+		{patchType, `[{"op": "remove", "path": "/Tags/-"}]`, 422, original, ""},
+		{patchType, `[{"op": "add", "path": "/Name/x", "value": 1}]`, 422, original, ""},
+		{patchType, `[{"op": "move", "from": "/attrs", "path": "/attrs/x"}]`, 422, original, ""},
+		{patchType, `[{"op": "remove", "path": ""}]`, 422, original, ""},
+		{patchType, `[{"op": "replace", "path": "", "value": [1]}]`, 422, original, ""},
+		{patchType, `[{"op": "replace", "path": "/Name", "value": 1}]`, 422, original, ""},
+		{mergeType, `[1]`, 422, original, ""},
+		{"application/json", `{"Name": "e2"}`, 415, original, ""},
+	}
+	for _, c := range cases {
+		stored = patched{Name: "e1", Tags: []string{"a", "b"}, Attrs: map[string]interface{}{"a/b": 1, "m~n": 2}}
+		changed = nil
+		h.CheckEqual(t, status(t, "PATCH", srv.URL+"/patched/e1", c.contentType, c.patch), c.code)
+		h.CheckEqual(t, summary(), c.summary)
+		h.CheckEqual(t, strings.Join(changed, "|"), c.changed)
+	}
+}
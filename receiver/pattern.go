@@ -0,0 +1,119 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gathering/gondulapi"
+)
+
+// segment is a single part of a path pattern, split on "/". It is either
+// a literal that must match exactly, or a named parameter.
+type segment struct {
+	literal string
+	name    string
+	kind    string // "string" or "int" for parameters
+}
+
+// pattern is a parsed path pattern, e.g.
+// "/test/track/{track}/station/{station:int}". The base is the literal
+// part up to the last slash before the first parameter, and is what is
+// registered with net/http. The segments cover the rest.
+type pattern struct {
+	raw      string
+	base     string
+	segments []segment
+}
+
+// parsePattern parses url as a pattern. If url has no parameters, the
+// pattern is returned with only a base, and matches anything below it,
+// same as net/http does.
+func parsePattern(url string) (pattern, error) {
+	p := pattern{raw: url, base: url}
+	first := strings.Index(url, "{")
+	if first == -1 {
+		return p, nil
+	}
+	p.base = url[:strings.LastIndex(url[:first], "/")+1]
+	for _, part := range strings.Split(url[len(p.base):], "/") {
+		if !strings.HasPrefix(part, "{") {
+			if strings.ContainsAny(part, "{}") {
+				return p, fmt.Errorf("parameters must span an entire path segment, got %q in %s", part, url)
+			}
+			p.segments = append(p.segments, segment{literal: part})
+			continue
+		}
+		if !strings.HasSuffix(part, "}") {
+			return p, fmt.Errorf("unterminated parameter %q in %s", part, url)
+		}
+		name, kind, _ := strings.Cut(part[1:len(part)-1], ":")
+		if kind == "" {
+			kind = "string"
+		}
+		if name == "" {
+			return p, fmt.Errorf("unnamed parameter in %s", url)
+		}
+		if kind != "string" && kind != "int" {
+			return p, fmt.Errorf("unknown type %q for parameter %s in %s, use string or int", kind, name, url)
+		}
+		p.segments = append(p.segments, segment{name: name, kind: kind})
+	}
+	return p, nil
+}
+
+// match matches element, the path after the base, against the pattern.
+// It returns a 404 gondulapi.Error if the structure of the path doesn't
+// match, and a 400 if a parameter can't be converted to its type. A
+// single trailing slash is ignored.
+func (p pattern) match(element string) (gondulapi.Params, error) {
+	params := make(gondulapi.Params)
+	if len(p.segments) == 0 {
+		return params, nil
+	}
+	parts := strings.Split(strings.TrimSuffix(element, "/"), "/")
+	if len(parts) != len(p.segments) {
+		return nil, gondulapi.Errorf(404, "No such path, expected %s", p.raw)
+	}
+	for idx, seg := range p.segments {
+		part := parts[idx]
+		if seg.name == "" {
+			if part != seg.literal {
+				return nil, gondulapi.Errorf(404, "No such path, expected %s", p.raw)
+			}
+			continue
+		}
+		if part == "" {
+			return nil, gondulapi.Errorf(404, "No such path, %s can't be blank in %s", seg.name, p.raw)
+		}
+		if seg.kind == "int" {
+			i, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, gondulapi.Errorf(400, "Invalid value for %s, expected an integer, got %q", seg.name, part)
+			}
+			params[seg.name] = i
+			continue
+		}
+		params[seg.name] = part
+	}
+	return params, nil
+}
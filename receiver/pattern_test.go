@@ -0,0 +1,87 @@
+/*
+Gondul GO API, receiver path pattern tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+// located echoes the parameters it got from the path.
+type located struct {
+	Site    string
+	Box     int
+	Element string
+}
+
+func (l *located) Get(request *gondulapi.Request) (gondulapi.Report, error) {
+	l.Site, _ = request.Params["site"].(string)
+	l.Box, _ = request.Params["box"].(int)
+	l.Element = request.Element
+	return gondulapi.Report{}, nil
+}
+
+func init() {
+	receiver.AddHandler("/located/site/{site}/box/{box:int}", func() interface{} { return &located{} })
+	receiver.AddHandler("/unlocated/", func() interface{} { return &located{} })
+}
+
+func TestPattern(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	cases := []struct {
+		path    string
+		code    int
+		site    string
+		box     int
+		element string
+	}{
+		{"/located/site/e1/box/3", 200, "e1", 3, "e1/box/3"},
+		{"/located/site/e1/box/3/", 200, "e1", 3, "e1/box/3/"},
+		{"/located/site/ring%20a/box/-1", 200, "ring a", -1, "ring a/box/-1"},
+		{"/located/site/e1/box/three", 400, "", 0, ""},
+		{"/located/site/e1/box/", 404, "", 0, ""},
+		{"/located/site//box/3", 404, "", 0, ""},
+		{"/located/site/e1/crate/3", 404, "", 0, ""},
+		{"/located/site/e1/box/3/lid", 404, "", 0, ""},
+		{"/located/", 404, "", 0, ""},
+		{"/unlocated/anything/at/all", 200, "", 0, "anything/at/all"},
+	}
+	for _, c := range cases {
+		resp, err := http.Get(srv.URL + c.path)
+		h.CheckEqual(t, err, nil)
+		h.CheckEqual(t, resp.StatusCode, c.code)
+		if c.code == 200 {
+			var l located
+			h.CheckEqual(t, json.NewDecoder(resp.Body).Decode(&l), nil)
+			h.CheckEqual(t, l.Site, c.site)
+			h.CheckEqual(t, l.Box, c.box)
+			h.CheckEqual(t, l.Element, c.element)
+		}
+		resp.Body.Close()
+	}
+}
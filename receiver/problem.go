@@ -0,0 +1,127 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/gathering/gondulapi"
+	"github.com/gathering/gondulapi/log"
+)
+
+// problemType is the media type of error replies in JSON, see
+// gondulapi.Problem.
+const problemType = "application/problem+json"
+
+// problem describes err as the reply to r, with code as the status. Only
+// gondulapi.Error and FieldErrors are shown to the client, anything else
+// is logged and replaced with the status text, since it could be about
+// anything.
+func problem(r *http.Request, code int, err error) gondulapi.Problem {
+	var gerr gondulapi.Error
+	var fields gondulapi.FieldErrors
+	switch {
+	case err == nil:
+		gerr = gondulapi.Errori(code, gondulapi.Problem{})
+	case errors.As(err, &gerr):
+	case errors.As(err, &fields):
+		gerr = gondulapi.Errori(code, fields)
+	default:
+		log.Context(r.Context()).Printf("Replying %d to %s %s after: %v", code, r.Method, r.URL.Path, err)
+		gerr = gondulapi.Errorf(code, "%s", http.StatusText(code))
+	}
+	p := gerr.Problem()
+	p.Status = code
+	if p.Type == "about:blank" {
+		p.Title = http.StatusText(code)
+	}
+	p.Instance = r.URL.Path
+	p.RequestID = log.RequestID(r.Context())
+	return p
+}
+
+// fail answers r with err, with the status of the gondulapi.Error it is
+// or wraps, or a 500 for anything else, which problem logs instead of
+// showing.
+func (rcvr receiver) fail(w http.ResponseWriter, r *http.Request, err error, pretty bool) {
+	code := 500
+	var gerr gondulapi.Error
+	if errors.As(err, &gerr) {
+		code = gerr.Code
+	}
+	rcvr.answer(w, r, output{code: code, data: gondulapi.Report{Error: err}}, pretty)
+}
+
+// kind describes what t looks like in JSON, for decoding errors.
+func kind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	case reflect.Ptr:
+		return kind(t.Elem())
+	}
+	return "an object"
+}
+
+// jsonError turns an error from decoding a JSON body into a 400 saying
+// what is wrong and where. Errors that already are a gondulapi.Error,
+// e.g. from an UnmarshalJSON, are returned as they are.
+func jsonError(err error) error {
+	var gerr gondulapi.Error
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &gerr):
+		return err
+	case errors.As(err, &syntax):
+		return gondulapi.Errori(400, gondulapi.FieldErrors{{
+			Message: fmt.Sprintf("Invalid JSON at offset %d: %v", syntax.Offset, syntax),
+			Offset:  syntax.Offset,
+		}})
+	case errors.As(err, &typ):
+		if typ.Field == "" {
+			return gondulapi.Errori(400, gondulapi.FieldErrors{{
+				Message: fmt.Sprintf("The body must be %s, not %s", kind(typ.Type), typ.Value),
+				Offset:  typ.Offset,
+			}})
+		}
+		return gondulapi.Errori(400, gondulapi.FieldErrors{{
+			Field:   typ.Field,
+			Message: fmt.Sprintf("must be %s, not %s", kind(typ.Type), typ.Value),
+			Offset:  typ.Offset,
+		}})
+	}
+	return gondulapi.Errorf(400, "Unable to decode the body: %v", err)
+}
@@ -0,0 +1,122 @@
+/*
+Gondul GO API, receiver error reply tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+// troubled fails in all the ways an object can.
+type troubled struct {
+	Name  string
+	Count int
+}
+
+func (tr *troubled) Get(element string) (gondulapi.Report, error) {
+	switch element {
+	case "locked":
+		return gondulapi.Report{}, gondulapi.Errori(409, gondulapi.Problem{
+			Type:   "https://example.com/problems/locked",
+			Title:  "Locked",
+			Detail: "The switch is locked",
+		})
+	case "plain":
+		return gondulapi.Report{}, fmt.Errorf("secret database details")
+	}
+	return gondulapi.Report{}, gondulapi.Errorf(404, "No %s here", element)
+}
+
+func (tr *troubled) Put(element string) (gondulapi.Report, error) {
+	return gondulapi.Report{Failed: 1}, gondulapi.Errorf(409, "%s is locked", element)
+}
+
+func init() {
+	receiver.AddHandler("/troubled/", func() interface{} { return &troubled{} })
+}
+
+// problem sends body to url with method and returns the reply, which
+// must be a problem.
+func problem(t *testing.T, method string, url string, body string) gondulapi.Problem {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("X-Request-ID", "problem-1")
+	resp, err := http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	defer resp.Body.Close()
+	h.CheckEqual(t, resp.Header.Get("Content-Type"), "application/problem+json")
+	var p gondulapi.Problem
+	h.CheckEqual(t, json.NewDecoder(resp.Body).Decode(&p), nil)
+	h.CheckEqual(t, p.Status, resp.StatusCode)
+	h.CheckEqual(t, p.RequestID, "problem-1")
+	return p
+}
+
+func TestProblem(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	p := problem(t, "GET", srv.URL+"/troubled/x", "")
+	h.CheckEqual(t, p.Status, 404)
+	h.CheckEqual(t, p.Type, "about:blank")
+	h.CheckEqual(t, p.Title, "Not Found")
+	h.CheckEqual(t, p.Detail, "No x here")
+	h.CheckEqual(t, p.Instance, "/troubled/x")
+
+	p = problem(t, "GET", srv.URL+"/troubled/locked", "")
+	h.CheckEqual(t, p.Status, 409)
+	h.CheckEqual(t, p.Type, "https://example.com/problems/locked")
+	h.CheckEqual(t, p.Title, "Locked")
+	h.CheckEqual(t, p.Detail, "The switch is locked")
+
+	// Errors that aren't meant for the client don't reach it.
+	p = problem(t, "GET", srv.URL+"/troubled/plain", "")
+	h.CheckEqual(t, p.Status, 500)
+	h.CheckEqual(t, p.Detail, "Internal Server Error")
+
+	p = problem(t, "PUT", srv.URL+"/troubled/x", `{"Name": "x"}`)
+	h.CheckEqual(t, p.Status, 409)
+	h.CheckEqual(t, p.Detail, "x is locked")
+
+	p = problem(t, "PUT", srv.URL+"/troubled/x", `{"Name": "x",}`)
+	h.CheckEqual(t, p.Status, 400)
+	h.CheckEqual(t, len(p.Errors), 1)
+	h.CheckEqual(t, p.Errors[0].Offset, int64(14))
+	h.CheckEqual(t, strings.HasPrefix(p.Detail, "Invalid JSON at offset 14: invalid character '}'"), true)
+
+	p = problem(t, "PUT", srv.URL+"/troubled/x", `{"Name": "x", "Count": "many"}`)
+	h.CheckEqual(t, p.Status, 400)
+	h.CheckEqual(t, len(p.Errors), 1)
+	h.CheckEqual(t, p.Errors[0].Field, "Count")
+	h.CheckEqual(t, p.Errors[0].Message, "must be an integer, not string")
+	h.CheckEqual(t, p.Errors[0].Offset, int64(29))
+
+	p = problem(t, "PUT", srv.URL+"/troubled/x", `[]`)
+	h.CheckEqual(t, p.Status, 400)
+	h.CheckEqual(t, p.Detail, "The body must be an object, not array")
+}
@@ -25,9 +25,19 @@ RESTful HTTP API with certain patterns implicitly enforced:
 data structures. E.g.: What you PUT is the same as what you GET out
 again. No cheating.
 
-- ETag is computed for all responses.
+- ETag is computed for all responses. GET and HEAD honour If-None-Match
+with 304 Not Modified, while PUT and DELETE honour If-Match and
+If-None-Match by comparing with what GET would have returned, replying
+412 Precondition Failed on mismatch.
 
-- All responses are JSON-encoded, including error messages.
+- All responses are JSON-encoded, including error messages, unless the
+client asks for one of the other registered formats (YAML, MessagePack
+and CSV are built in) with Accept or ?format=. Request bodies are decoded
+according to their Content-Type.
+
+- The methods an object supports follow from the interfaces it
+implements. HEAD and OPTIONS are provided automatically, and anything
+else is answered with 405 Method Not Allowed, all with an Allow header.
 
 See objects/thing.go for how to use this, but the essence is:
 
@@ -42,9 +52,10 @@ don't have to.
 package receiver
 
 import (
-	"fmt"
-	"net/http"
-	"strings"
+	"context"
+	"os/signal"
+	"syscall"
+	"time"
 
 	gapi "github.com/gathering/gondulapi"
 	"github.com/gathering/gondulapi/log"
@@ -53,59 +64,127 @@ import (
 // AddHandler registeres an allocator/data structure with a url. The
 // allocator should be a function returning an empty datastrcuture which
 // implements one or more of gondulapi.Getter, Putter, Poster and Deleter
-func AddHandler(url string, a Allocator) {
+//
+// The url can be a pattern with named parameters, each spanning a full
+// path segment, e.g. "/test/track/{track}/station/{station:int}". A
+// parameter is a string unless declared as :int. The receiver matches
+// the path and converts the parameters before handing them to the object
+// as gondulapi.Request.Params, which requires the Request-variants of the
+// interfaces, e.g. gondulapi.RequestGetter. Paths that don't match get a
+// 404, parameters that don't convert a 400. POST is not matched against
+// the pattern, since it doesn't address an element.
+func AddHandler(url string, a Allocator, opts ...Option) {
 	if handles == nil {
-		handles = make(map[string]Allocator)
+		handles = make(map[string]receiver)
+	}
+	rcvr := receiver{alloc: a}
+	for _, opt := range opts {
+		opt(&rcvr)
+	}
+	handles[url] = rcvr
+}
+
+// Option configures a single handler, and is passed to AddHandler.
+type Option func(*receiver)
+
+// Timeout sets how long requests to the handler may take, overriding
+// gondulapi.Config.RequestTimeout, or -1 for no limit. When it's up, the
+// context of the request is cancelled, which the db Context-functions
+// answer with a 503. Objects that ignore the context are not interrupted.
+func Timeout(timeout time.Duration) Option {
+	return func(rcvr *receiver) {
+		rcvr.timeout = timeout
+	}
+}
+
+// PageSize sets the default and maximum page size for GETs of the
+// handler, overriding gondulapi.Config.PageSize and MaxPageSize. Either
+// can be 0 to use the configured value, and a max of -1 allows fetching
+// everything. See db.SelectQuery.
+func PageSize(def int, max int) Option {
+	return func(rcvr *receiver) {
+		rcvr.pageSize = def
+		rcvr.maxPageSize = max
+	}
+}
+
+// TotalCount makes GETs of the handler count the total number of matches
+// in addition to the page, returned as X-Total-Count. It costs an extra
+// query, so it is opt-in.
+func TotalCount() Option {
+	return func(rcvr *receiver) {
+		rcvr.total = true
+	}
+}
+
+// MaxBodySize sets the maximum size of request bodies for the handler in
+// bytes, overriding gondulapi.Config.MaxBodySize. Larger bodies get a 413.
+func MaxBodySize(max int64) Option {
+	return func(rcvr *receiver) {
+		rcvr.maxBodySize = max
+	}
+}
+
+// StrictJSON sets whether JSON bodies sent to the handler are decoded
+// strictly, overriding gondulapi.Config.StrictJSON. Strictly means that
+// keys must match a field exactly, case included, that no key may appear
+// twice in the same object and that nothing may follow the document.
+// Anything else is a 400 naming the offending fields. Other formats are
+// decoded as usual.
+func StrictJSON(strict bool) Option {
+	return func(rcvr *receiver) {
+		rcvr.strict = &strict
 	}
-	handles[url] = a
 }
 
 // Allocator is used to allocate a data structure that implements at least
 // one of Getter, Putter, Poster or Deleter from gondulapi.
 type Allocator func() interface{}
 
-func findInterfaces(item interface{}) string {
-	s := make([]string,0)
+// findInterfaces lists the HTTP methods item supports, based on which
+// interfaces it implements. HEAD comes with GET, and OPTIONS is always
+// supported. It is used for the Allow header.
+func findInterfaces(item interface{}) []string {
+	s := make([]string, 0)
 	_, ok := item.(gapi.Getter)
-	if ok {
-		s = append(s, "GET")
+	_, rok := item.(gapi.RequestGetter)
+	_, cok := item.(gapi.ContextGetter)
+	if ok || rok || cok {
+		s = append(s, "GET", "HEAD")
 	}
 	_, ok = item.(gapi.Putter)
-	if ok {
+	_, rok = item.(gapi.RequestPutter)
+	_, cok = item.(gapi.ContextPutter)
+	if ok || rok || cok {
 		s = append(s, "PUT")
 	}
 	_, ok = item.(gapi.Poster)
-	if ok {
+	_, cok = item.(gapi.ContextPoster)
+	if ok || cok {
 		s = append(s, "POST")
 	}
+	_, ok = item.(gapi.Patcher)
+	_, rok = item.(gapi.RequestPatcher)
+	_, cok = item.(gapi.ContextPatcher)
+	if ok || rok || cok {
+		s = append(s, "PATCH")
+	}
 	_, ok = item.(gapi.Deleter)
-	if ok {
+	_, rok = item.(gapi.RequestDeleter)
+	_, cok = item.(gapi.ContextDeleter)
+	if ok || rok || cok {
 		s = append(s, "DELETE")
 	}
-	return strings.Join(s, " ")
-
+	return append(s, "OPTIONS")
 }
-// Start a net/http server and handle all requests registered. Never
-// returns.
+
+// Start a net/http server and handle all requests registered, until the
+// process gets SIGTERM or SIGINT. The requests in flight are allowed to
+// finish before it returns. See Server for more control.
 func Start() {
-	server := http.Server{}
-	serveMux := http.NewServeMux()
-	server.Handler = serveMux
-	if gapi.Config.Prefix != "" {
-		log.Tracef("Prefixing URLs with %s", gapi.Config.Prefix)
-	}
-	for idx, h := range handles {
-		target := fmt.Sprintf("%s%s", gapi.Config.Prefix, idx)
-		s := findInterfaces(h())
-		log.Printf("Listening for %v (%T) - %s\n", target, h(), s)
-		serveMux.Handle(target, receiver{alloc: h, path: target})
-	}
-	if gapi.Config.ListenAddress == "" {
-		log.Printf("No listenaddress configured, using default :8080")
-		server.Addr = ":8080"
-	} else {
-		server.Addr = gapi.Config.ListenAddress
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	if err := NewServer().Start(ctx); err != nil {
+		log.Fatal(err)
 	}
-	log.Printf("Starting HTTP receiver on %s", server.Addr)
-	log.Fatal(server.ListenAndServe())
 }
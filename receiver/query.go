@@ -0,0 +1,220 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gathering/gondulapi"
+)
+
+// reserved query parameters are used by the receiver itself, and never
+// treated as filters.
+var reserved = map[string]bool{
+	"pretty": true,
+	"limit":  true,
+	"offset": true,
+	"cursor": true,
+	"sort":   true,
+	"fields": true,
+	"format": true,
+}
+
+// operators maps the operators accepted in the query string to the
+// names used in gondulapi.Filter. Two-character operators must come
+// first, since the search picks the first that matches.
+var operators = []struct {
+	token string
+	name  string
+}{
+	{">=", "ge"},
+	{"<=", "le"},
+	{"!=", "ne"},
+	{"~=", "like"},
+	{"=", "eq"},
+	{">", "gt"},
+	{"<", "lt"},
+}
+
+// parseQuery parses the raw query string into a gondulapi.Query. It has
+// to work on the raw string since url.ParseQuery only knows about "=",
+// e.g. "last_updated>=2020" would end up as "last_updated>" = "2020".
+// Each term is unescaped before looking for the operator, so it can be
+// escaped as well, e.g. "last_updated%3E=2020". The first operator wins,
+// so values can contain operators, but field names can't. Terms without
+// an operator, like "?pretty", are flags and not filters.
+//
+// Sort and fields are comma-separated lists, e.g.
+// "?sort=-time,sysname&fields=sysname,mgmt_v4_addr".
+//
+// Only the syntax is checked here. Which fields and operators are
+// allowed depends on the object, and is checked by db.SelectQuery, which
+// ignores plain key=value terms for fields it can't filter on.
+func parseQuery(raw string) (gondulapi.Query, error) {
+	var query gondulapi.Query
+	for _, term := range strings.Split(raw, "&") {
+		term, err := url.QueryUnescape(term)
+		if err != nil {
+			return query, gondulapi.Errorf(400, "Invalid query string: %v", err)
+		}
+		pos := strings.IndexAny(term, "=!~<>")
+		if pos == -1 {
+			continue
+		}
+		op, value := "", ""
+		for _, candidate := range operators {
+			if strings.HasPrefix(term[pos:], candidate.token) {
+				op = candidate.name
+				value = term[pos+len(candidate.token):]
+				break
+			}
+		}
+		if op == "" {
+			return query, gondulapi.Errorf(400, "Invalid query string, unknown operator in %q", term)
+		}
+		field := term[:pos]
+		if (field == "sort" || field == "fields") && op == "eq" {
+			list := strings.Split(value, ",")
+			if field == "sort" {
+				query.Sort = list
+			} else {
+				query.Fields = list
+			}
+			continue
+		}
+		if reserved[field] {
+			continue
+		}
+		query.Filter = append(query.Filter, gondulapi.Filter{Field: field, Op: op, Value: value})
+	}
+	return query, nil
+}
+
+// paging fills in the paging-part of query from the query string, using
+// the page sizes of the handler, or the configured ones. Without either,
+// there is no limit unless the client asks for one. A limit above the
+// maximum is silently lowered to the maximum: the Link header tells the
+// client where to go next anyway.
+func (rcvr receiver) paging(values url.Values, query *gondulapi.Query) error {
+	def, max := rcvr.pageSize, rcvr.maxPageSize
+	if def == 0 {
+		def = gondulapi.Config.PageSize
+	}
+	if max == 0 {
+		max = gondulapi.Config.MaxPageSize
+	}
+	if def == 0 || (max > 0 && def > max) {
+		def = max
+	}
+	query.Limit = def
+	if query.Limit < 0 {
+		query.Limit = 0
+	}
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return gondulapi.Errorf(400, "Invalid limit %q, must be a positive integer", v)
+		}
+		query.Limit = n
+	}
+	if max > 0 && query.Limit > max {
+		query.Limit = max
+	}
+	if v := values.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return gondulapi.Errorf(400, "Invalid offset %q, must be a non-negative integer", v)
+		}
+		query.Offset = n
+	}
+	query.Cursor = values.Get("cursor")
+	query.Total = rcvr.total
+	return nil
+}
+
+var brackets = strings.NewReplacer("<", "%3C", ">", "%3E")
+
+// links builds a RFC 8288 Link header pointing to the neighbouring pages
+// of page, relative to u. It works on the raw query string for the same
+// reason parseQuery does, keeping everything but the previous position.
+// Since < and > delimit the link, they are escaped.
+func links(u *url.URL, page *gondulapi.Page) string {
+	terms := make([]string, 0)
+	for _, term := range strings.Split(u.RawQuery, "&") {
+		key := term
+		if pos := strings.IndexAny(term, "=!~<>"); pos != -1 {
+			key = term[:pos]
+		}
+		if term == "" || key == "cursor" || key == "offset" {
+			continue
+		}
+		terms = append(terms, brackets.Replace(term))
+	}
+	rels := make([]string, 0)
+	for _, rel := range []struct {
+		name  string
+		query string
+	}{{"next", page.Next}, {"prev", page.Prev}} {
+		if rel.query == "" {
+			continue
+		}
+		ref := url.URL{Path: u.Path, RawQuery: strings.Join(append(terms[:len(terms):len(terms)], rel.query), "&")}
+		rels = append(rels, fmt.Sprintf("<%s>; rel=\"%s\"", ref.String(), rel.name))
+	}
+	return strings.Join(rels, ", ")
+}
+
+// sparse leaves out everything but fields from data, which is either a
+// single object or a list of them. It is the receiver-side of sparse
+// fieldsets, where db has only fetched some fields, see Report.Fields.
+func sparse(data interface{}, fields []string) (interface{}, error) {
+	keep := make(map[string]bool)
+	for _, field := range fields {
+		keep[field] = true
+	}
+	prune := func(obj map[string]json.RawMessage) {
+		for k := range obj {
+			if !keep[k] {
+				delete(obj, k)
+			}
+		}
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]map[string]json.RawMessage, 0)
+	if err := json.Unmarshal(b, &list); err == nil {
+		for _, obj := range list {
+			prune(obj)
+		}
+		return list, nil
+	}
+	obj := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+	prune(obj)
+	return obj, nil
+}
@@ -0,0 +1,95 @@
+/*
+Gondul GO API, receiver query string tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+// queried echoes the query it got, with the filters as "field op value".
+type queried struct {
+	Filter []string
+	Sort   []string
+	Fields []string
+	Limit  int
+}
+
+func (q *queried) Get(request *gondulapi.Request) (gondulapi.Report, error) {
+	for _, f := range request.Query.Filter {
+		q.Filter = append(q.Filter, fmt.Sprintf("%s %s %s", f.Field, f.Op, f.Value))
+	}
+	q.Sort = request.Query.Sort
+	q.Fields = request.Query.Fields
+	q.Limit = request.Query.Limit
+	return gondulapi.Report{}, nil
+}
+
+func init() {
+	receiver.AddHandler("/queried/", func() interface{} { return &queried{} })
+}
+
+func TestQuery(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	cases := []struct {
+		query  string
+		code   int
+		filter string
+		sort   string
+		fields string
+	}{
+		{"", 200, "", "", ""},
+		{"sysname=e1-1", 200, "sysname eq e1-1", "", ""},
+		{"sysname!=e1-1", 200, "sysname ne e1-1", "", ""},
+		{"sysname~=e1-%25", 200, "sysname like e1-%", "", ""},
+		{"vlan>10&vlan>=11&vlan<20&vlan<=19", 200, "vlan gt 10|vlan ge 11|vlan lt 20|vlan le 19", "", ""},
+		{"last_updated%3E=2020-04-01", 200, "last_updated ge 2020-04-01", "", ""},
+		{"note=a>=b", 200, "note eq a>=b", "", ""},
+		{"_=123&utm_source=mail", 200, "_ eq 123|utm_source eq mail", "", ""},
+		{"pretty&limit=5&offset=5&format=json", 200, "", "", ""},
+		{"sort=-time,sysname&fields=sysname,vlan", 200, "", "-time|sysname", "sysname|vlan"},
+		{"sort>=time&limit!=3", 200, "", "", ""},
+		{"vlan!10", 400, "", "", ""},
+		{"vlan=%zz", 400, "", "", ""},
+	}
+	for _, c := range cases {
+		resp, err := http.Get(srv.URL + "/queried/?" + c.query)
+		h.CheckEqual(t, err, nil)
+		h.CheckEqual(t, resp.StatusCode, c.code)
+		if c.code == 200 {
+			var q queried
+			h.CheckEqual(t, json.NewDecoder(resp.Body).Decode(&q), nil)
+			h.CheckEqual(t, strings.Join(q.Filter, "|"), c.filter)
+			h.CheckEqual(t, strings.Join(q.Sort, "|"), c.sort)
+			h.CheckEqual(t, strings.Join(q.Fields, "|"), c.fields)
+		}
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,259 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gathering/gondulapi"
+	"github.com/gathering/gondulapi/log"
+)
+
+// RateLimit limits requests to a single handler. Limits in
+// gondulapi.Config.RateLimits for the same handler path replace these, so
+// they can be adjusted without changing the code. Path is ignored.
+func RateLimit(limits ...gondulapi.RateLimit) Option {
+	return func(rcvr *receiver) {
+		rcvr.rateLimits = append(rcvr.rateLimits, limits...)
+	}
+}
+
+// bucket is the token bucket of a single client.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter keeps the buckets for a single rate limit.
+type limiter struct {
+	limit   gondulapi.RateLimit
+	burst   float64
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newLimiter(limit gondulapi.RateLimit) *limiter {
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = math.Ceil(limit.Rate)
+	}
+	return &limiter{limit: limit, burst: burst, buckets: make(map[string]*bucket)}
+}
+
+// applies checks if the limit covers method.
+func (l *limiter) applies(method string) bool {
+	if len(l.limit.Methods) == 0 {
+		return true
+	}
+	for _, m := range l.limit.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// take takes a token from the bucket of key, if there is one. It returns
+// the tokens left, how long until the next token is available and how
+// long until the bucket is full again. Buckets that have filled up are
+// forgotten now and then, since they are no different from new ones.
+func (l *limiter) take(key string, now time.Time) (ok bool, remaining int, wait time.Duration, reset time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > time.Minute {
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	}
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	}
+	reset = time.Duration((l.burst - b.tokens) / l.limit.Rate * float64(time.Second))
+	return ok, int(b.tokens), wait, reset
+}
+
+// newLimiters returns the limiters for rcvr, from the config if it has any
+// for the handler, otherwise those set with the RateLimit option. Limits
+// in the config without a path are added to all handlers.
+func (rcvr receiver) newLimiters() []*limiter {
+	own := rcvr.rateLimits
+	var global []gondulapi.RateLimit
+	replaced := false
+	for _, limit := range gondulapi.Config.RateLimits {
+		switch limit.Path {
+		case "":
+			global = append(global, limit)
+		case rcvr.path, rcvr.pattern.raw:
+			if !replaced {
+				own, replaced = nil, true
+			}
+			own = append(own, limit)
+		}
+	}
+	var limiters []*limiter
+	for _, limit := range append(global, own...) {
+		if limit.Rate <= 0 {
+			log.Printf("Ignoring rate limit for %s without a rate", rcvr.path)
+			continue
+		}
+		limiters = append(limiters, newLimiter(limit))
+	}
+	return limiters
+}
+
+// perUser checks if any of the limits of rcvr count per user, which is
+// when authenticate has to find out who the user is.
+func (rcvr receiver) perUser() bool {
+	for _, l := range rcvr.limiters {
+		if l.limit.PerUser {
+			return true
+		}
+	}
+	return false
+}
+
+// address returns the remote address of r, without the port.
+func address(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host
+}
+
+// client returns the key to count r by, which is the remote address, or
+// the user verified by authenticate if perUser is set and there is one.
+// Users that haven't been verified, e.g. for a GET with auth.ReadPublic,
+// are counted by address, or clients could make up new users to get
+// around the limit.
+func client(r *http.Request, perUser bool) string {
+	if perUser {
+		if user, _ := r.Context().Value(userKey{}).(string); user != "" {
+			return "user:" + user
+		}
+	}
+	return "addr:" + address(r)
+}
+
+// failed returns the key failed authentication is counted by for r. It
+// is separate from the address, so anonymous requests from behind the
+// same address as a user don't use up the limits of that user.
+func failed(r *http.Request) string {
+	return "failed:" + address(r)
+}
+
+// rateLimit enforces the rate limits of rcvr that count per remote
+// address. It comes before authentication, so requests that fail it are
+// limited too. Limits per user are enforced by userRateLimit, after
+// authentication, and by failedAuth for requests that fail it.
+func (rcvr receiver) rateLimit(next http.Handler) http.Handler {
+	if len(rcvr.limiters) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rcvr.limit(w, r, false, client(r, false)) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// userRateLimit enforces the rate limits of rcvr that count per user,
+// see client.
+func (rcvr receiver) userRateLimit(next http.Handler) http.Handler {
+	if !rcvr.perUser() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rcvr.limit(w, r, true, client(r, true)) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// failedAuth counts a request that failed authentication against the
+// limits per user, by address, since there is no user to count it
+// against. It returns false after answering with a 429 if the address
+// has failed too often. Only failures count, so users behind the same
+// address who get their password right aren't affected.
+func (rcvr receiver) failedAuth(w http.ResponseWriter, r *http.Request) bool {
+	return rcvr.limit(w, r, true, failed(r))
+}
+
+// limit takes a token for key from every limit that covers the method
+// and counts per user if perUser is set, or per address if not. Every
+// limit has to have a token left, or r gets a 429 and limit returns
+// false. The X-RateLimit headers describe the limit closest to running
+// out, unless they already describe one closer to it: its size, the
+// requests left and the seconds until it is full again.
+func (rcvr receiver) limit(w http.ResponseWriter, r *http.Request, perUser bool, key string) bool {
+	now := time.Now()
+	var tightest *limiter
+	remaining, wait, reset, ok := 0, time.Duration(0), time.Duration(0), true
+	for _, l := range rcvr.limiters {
+		if l.limit.PerUser != perUser || !l.applies(r.Method) {
+			continue
+		}
+		lok, lremaining, lwait, lreset := l.take(key, now)
+		if tightest == nil || lremaining < remaining {
+			tightest, remaining, reset = l, lremaining, lreset
+		}
+		if lwait > wait {
+			wait = lwait
+		}
+		ok = ok && lok
+	}
+	if tightest == nil {
+		return true
+	}
+	if prev, err := strconv.Atoi(w.Header().Get("X-RateLimit-Remaining")); err != nil || remaining <= prev {
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(tightest.burst)))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+	}
+	if !ok {
+		seconds := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+		w.Header().Set("Retry-After", seconds)
+		err := gondulapi.Errorf(429, "Too many requests, try again in %s seconds", seconds)
+		rcvr.answer(w, r, output{code: 429, data: gondulapi.Report{Error: err}}, len(r.URL.Query()["pretty"]) > 0)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,194 @@
+/*
+Gondul GO API, receiver rate limit tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	"github.com/gathering/gondulapi/auth"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+// public needs basic auth for everything but reading.
+type public struct {
+	auth.ReadPublic
+	Name string
+}
+
+func (p *public) Get(element string) (gondulapi.Report, error) {
+	p.Name = element
+	return gondulapi.Report{}, nil
+}
+
+// counted counts how often it is asked to authenticate, and lets
+// everyone read.
+type counted struct {
+	Name string
+}
+
+var auths int
+
+func (c *counted) Auth(basepath string, element string, method string, user string, password string) error {
+	auths++
+	return auth.CheckReadPublic(basepath, element, method, user, password)
+}
+
+func (c *counted) Get(element string) (gondulapi.Report, error) {
+	return gondulapi.Report{}, nil
+}
+
+func init() {
+	receiver.AddHandler("/counted/", func() interface{} { return &counted{} })
+	receiver.AddHandler("/public/", func() interface{} { return &public{} })
+	receiver.AddHandler("/limited/", func() interface{} { return &thing{} }, receiver.RateLimit(gondulapi.RateLimit{
+		Methods: []string{"PUT"},
+		Rate:    0.01,
+		Burst:   2,
+	}))
+}
+
+func limited(t *testing.T, method string, url string, user string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader("{}"))
+	if user != "" {
+		req.SetBasicAuth(user, "secret")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	resp.Body.Close()
+	return resp
+}
+
+func TestRateLimit(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	resp := limited(t, "PUT", srv.URL+"/limited/x", "")
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, resp.Header.Get("X-RateLimit-Limit"), "2")
+	h.CheckEqual(t, resp.Header.Get("X-RateLimit-Remaining"), "1")
+	h.CheckEqual(t, resp.Header.Get("X-RateLimit-Reset"), "100")
+	resp = limited(t, "PUT", srv.URL+"/limited/x", "")
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, resp.Header.Get("X-RateLimit-Remaining"), "0")
+	resp = limited(t, "PUT", srv.URL+"/limited/x", "")
+	h.CheckEqual(t, resp.StatusCode, 429)
+	h.CheckEqual(t, resp.Header.Get("Retry-After"), "100")
+
+	// Only PUT is limited.
+	resp = limited(t, "GET", srv.URL+"/limited/x", "")
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, resp.Header.Get("X-RateLimit-Limit"), "")
+}
+
+func TestRateLimitConfig(t *testing.T) {
+	gondulapi.Config.HTTPUser, gondulapi.Config.HTTPPw = "admin", "secret"
+	gondulapi.Config.RateLimits = []gondulapi.RateLimit{
+		{Path: "/limited/", Rate: 1000, Burst: 1},
+		{Path: "/private/", Methods: []string{"GET"}, Rate: 0.01, Burst: 1, PerUser: true},
+		{Path: "/private/", Methods: []string{"PUT"}, Rate: 0.01, Burst: 1},
+		{Path: "/public/", Rate: 0.01, Burst: 1, PerUser: true},
+	}
+	defer func() {
+		gondulapi.Config.HTTPUser, gondulapi.Config.HTTPPw = "", ""
+		gondulapi.Config.RateLimits = nil
+	}()
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	// The config replaces the limit set in code.
+	resp := limited(t, "GET", srv.URL+"/limited/x", "")
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, resp.Header.Get("X-RateLimit-Limit"), "1")
+
+	resp = limited(t, "GET", srv.URL+"/private/x", "admin")
+	h.CheckEqual(t, resp.StatusCode, 200)
+	resp = limited(t, "GET", srv.URL+"/private/x", "admin")
+	h.CheckEqual(t, resp.StatusCode, 429)
+
+	// Failed authentication is counted against the limit per user by
+	// address, so guessing is limited too.
+	resp = limited(t, "GET", srv.URL+"/private/x", "")
+	h.CheckEqual(t, resp.StatusCode, 401)
+	h.CheckEqual(t, resp.Header.Get("X-RateLimit-Remaining"), "0")
+	resp = limited(t, "GET", srv.URL+"/private/x", "mallory")
+	h.CheckEqual(t, resp.StatusCode, 429)
+
+	// Limits per address come before authentication.
+	resp = limited(t, "PUT", srv.URL+"/private/x", "admin")
+	h.CheckEqual(t, resp.StatusCode, 405)
+	h.CheckEqual(t, resp.Header.Get("X-RateLimit-Limit"), "1")
+	resp = limited(t, "PUT", srv.URL+"/private/x", "mallory")
+	h.CheckEqual(t, resp.StatusCode, 429)
+
+	// Users that aren't verified, since reading doesn't need a password,
+	// are counted by address, so making up new ones doesn't help.
+	resp = limited(t, "GET", srv.URL+"/public/x", "alice")
+	h.CheckEqual(t, resp.StatusCode, 200)
+	resp = limited(t, "GET", srv.URL+"/public/x", "bob")
+	h.CheckEqual(t, resp.StatusCode, 429)
+	resp = limited(t, "PUT", srv.URL+"/public/x", "admin")
+	h.CheckEqual(t, resp.StatusCode, 405)
+}
+
+func TestRateLimitFailedAuth(t *testing.T) {
+	gondulapi.Config.HTTPUser, gondulapi.Config.HTTPPw = "admin", "secret"
+	gondulapi.Config.RateLimits = []gondulapi.RateLimit{{Path: "/private/", Rate: 0.01, Burst: 2, PerUser: true}}
+	defer func() {
+		gondulapi.Config.HTTPUser, gondulapi.Config.HTTPPw = "", ""
+		gondulapi.Config.RateLimits = nil
+	}()
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	// One client behind the address keeps failing...
+	codes := []int{401, 401, 429, 429}
+	for _, code := range codes {
+		resp := limited(t, "GET", srv.URL+"/private/x", "mallory")
+		h.CheckEqual(t, resp.StatusCode, code)
+	}
+	// ...while another behind the same address gets the password right
+	// and has a limit of its own.
+	resp := limited(t, "GET", srv.URL+"/private/x", "admin")
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, resp.Header.Get("X-RateLimit-Remaining"), "1")
+	resp = limited(t, "GET", srv.URL+"/private/x", "admin")
+	h.CheckEqual(t, resp.StatusCode, 200)
+	resp = limited(t, "GET", srv.URL+"/private/x", "admin")
+	h.CheckEqual(t, resp.StatusCode, 429)
+}
+
+func TestRateLimitAuthOnce(t *testing.T) {
+	gondulapi.Config.RateLimits = []gondulapi.RateLimit{{Path: "/counted/", Rate: 1000, PerUser: true}}
+	defer func() { gondulapi.Config.RateLimits = nil }()
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	// Finding out who the user is doesn't ask Auth again.
+	auths = 0
+	resp := limited(t, "GET", srv.URL+"/counted/x", "someone")
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, auths, 1)
+}
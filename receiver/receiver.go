@@ -20,104 +20,328 @@ Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
 package receiver
 
 import (
+	"context"
 	"crypto/sha256"
-	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strings"
+	"time"
 
 	"github.com/gathering/gondulapi"
 	"github.com/gathering/gondulapi/log"
-
 )
 
-var handles map[string]Allocator
+var handles map[string]receiver
 
 type input struct {
-	method string
-	public bool
-	data   []byte
-	url    *url.URL
+	ctx         context.Context
+	method      string
+	public      bool
+	strict      bool
+	data        []byte
+	contentType string
+	url         *url.URL
+	params      gondulapi.Params
+	query       gondulapi.Query
 }
 
 type output struct {
 	code         int
 	data         interface{}
-	headers	     map[string]string
+	headers      map[string]string
 	cachecontrol string
 }
 
 type receiver struct {
-	alloc Allocator
-	path  string
+	alloc       Allocator
+	path        string
+	pattern     pattern
+	pageSize    int
+	maxPageSize int
+	total       bool
+	maxBodySize int64
+	middleware  []Middleware
+	cors        *gondulapi.CORS
+	rateLimits  []gondulapi.RateLimit
+	limiters    []*limiter      // Made from rateLimits and the config by handler
+	authMethods map[string]bool // Methods that need credentials, found by handler for limits per user
+	timeout     time.Duration
+	strict      *bool
 }
 
-// answer replies to a HTTP request with the provided output, optionally
-// formatting the output prettily. It also calculates an ETag.
-func (rcvr receiver) answer(w http.ResponseWriter, output output, pretty bool) {
-	var b []byte
-	var err error
-	if pretty {
+// defaultMaxBodySize is used if neither the handler nor the config sets a
+// maximum body size.
+const defaultMaxBodySize = 10 << 20
+
+// answer replies to a HTTP request with the provided output, in the
+// format the client asked for, optionally formatting JSON prettily. It
+// also calculates an ETag, and replies with 304 Not Modified if the
+// client already has it. Replies above a configurable size are compressed
+// with zstd or gzip, if the client accepts it.
+//
+// If the output can't be represented in the format, e.g. a single object
+// as CSV, the reply is JSON instead. For a successful GET that is a 406,
+// since the client didn't get what it asked for.
+func (rcvr receiver) answer(w http.ResponseWriter, r *http.Request, output output, pretty bool) {
+	code := output.code
+	if report, ok := output.data.(gondulapi.Report); ok && code >= 400 {
+		output.data = problem(r, code, report.Error)
+	}
+	f, _ := negotiate(r)
+	b, err := f.codec.Marshal(output.data)
+	if err != nil && f.name != "json" {
+		log.Context(r.Context()).Printf("Unable to marshal %T as %s: %v", output.data, f.name, err)
+		if code < 300 && (r.Method == "GET" || r.Method == "HEAD") {
+			code = 406
+			output.data = problem(r, code, gondulapi.Errorf(code, "Unable to represent this as %s: %v", f.name, err))
+		}
+		f = formats[0]
+		b, err = f.codec.Marshal(output.data)
+	}
+	if err == nil && pretty && f.name == "json" {
 		b, err = json.MarshalIndent(output.data, "", "  ")
-	} else {
-		b, err = json.Marshal(output.data)
 	}
-	code := output.code
 	if err != nil {
-		log.Printf("Json marshal error: %v", err)
+		log.Context(r.Context()).Printf("Json marshal error: %v", err)
 		b = []byte(`{"Message": "JSON marshal error. Very weird."}`)
 		code = 500
 	}
-	etagraw := sha256.Sum256(b)
-	etagstr := hex.EncodeToString(etagraw[:])
-	w.Header().Set("ETag", etagstr)
-	w.Header().Set("Content-Type", "application/json")
+	if f.name == "json" {
+		b = append(b, '\n')
+	}
+	enc := ""
+	if code != 204 {
+		enc = acceptEncoding(r, len(b))
+	}
+	// HEAD gets the headers of the compressed reply, without the work.
+	if enc != "" && r.Method != "HEAD" {
+		if zb, err := compress(enc, b); err != nil {
+			log.Context(r.Context()).Printf("Unable to compress reply with %s: %v", enc, err)
+			enc = ""
+		} else {
+			b = zb
+		}
+	}
+	// The ETag is always computed on the compact JSON form, so it doesn't
+	// change with ?pretty. Other formats and encodings get a suffix, since
+	// they are different representations.
+	tag, err := etag(output.data)
+	if err == nil {
+		if f.name != "json" {
+			tag = fmt.Sprintf("%s-%s\"", strings.TrimSuffix(tag, "\""), f.name)
+		}
+		if enc != "" {
+			tag = fmt.Sprintf("%s-%s\"", strings.TrimSuffix(tag, "\""), enc)
+		}
+		w.Header().Set("ETag", tag)
+	}
+	w.Header().Add("Vary", "Accept")
+	if compressMinSize() >= 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	for k, v := range output.headers {
+		w.Header().Set(k, v)
+	}
+	if code == 200 && (r.Method == "GET" || r.Method == "HEAD") && etagMatch(r.Header.Get("If-None-Match"), tag, true) {
+		w.WriteHeader(304)
+		return
+	}
+	contentType := f.mediaTypes[0]
+	if _, ok := output.data.(gondulapi.Problem); ok && f.name == "json" {
+		contentType = problemType
+	}
+	w.Header().Set("Content-Type", contentType)
+	if enc != "" {
+		w.Header().Set("Content-Encoding", enc)
+	}
 	w.WriteHeader(code)
-	if code == 204 {
+	if code == 204 || r.Method == "HEAD" {
 		return
 	}
+	w.Write(b)
+}
 
-	fmt.Fprintf(w, "%s\n", b)
+// etag returns the ETag of data, which is the quoted sha256 of its
+// compact JSON encoding.
+func etag(data interface{}) (string, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return fmt.Sprintf("\"%s\"", hex.EncodeToString(sum[:])), nil
+}
+
+// etagMatch checks if tag is listed in header, which is the value of an
+// If-Match or If-None-Match header. "*" matches any tag. If weak is true,
+// the W/ prefix is ignored (as for If-None-Match), otherwise weak tags
+// never match (as for If-Match). If-Match is about the item, not the
+// representation, so without weak the format suffix is ignored too.
+func etagMatch(header string, tag string, weak bool) bool {
+	if header == "" || tag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		} else if !weak {
+			if pos := strings.Index(candidate, "-"); pos != -1 {
+				candidate = candidate[:pos] + "\""
+			}
+		}
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// precondition evaluates If-Match and If-None-Match for PUT, PATCH and DELETE by
+// fetching the current representation through the Getter of a freshly
+// allocated object, and comparing its ETag. If the precondition fails, ok
+// is false and the output is the 412 to send back.
+func (rcvr receiver) precondition(r *http.Request, params gondulapi.Params) (o output, ok bool) {
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if (r.Method != "PUT" && r.Method != "PATCH" && r.Method != "DELETE") || (ifMatch == "" && ifNoneMatch == "") {
+		return o, true
+	}
+	o.code = 412
+	item := rcvr.alloc()
+	request := gondulapi.Request{Element: r.URL.Path[len(rcvr.path):], Params: params, Context: r.Context()}
+	_, isget, err := callGet(item, &request)
+	if !isget {
+		o.data = gondulapi.Report{Error: gondulapi.Errorf(o.code, "Precondition failed: %s has no GET, so there is nothing to compare with", rcvr.path)}
+		return o, false
+	}
+	exists := true
+	if err != nil {
+		gerr, havegerr := err.(gondulapi.Error)
+		if !havegerr || gerr.Code != 404 {
+			log.Context(r.Context()).Printf("Precondition GET failed: %v", err)
+			o.code = 500
+			o.data = gondulapi.Report{Error: gondulapi.Errorf(o.code, "Precondition failed: unable to fetch the current item")}
+			return o, false
+		}
+		exists = false
+	}
+	tag := ""
+	if exists {
+		tag, err = etag(item)
+		if err != nil {
+			log.Context(r.Context()).Printf("Precondition ETag failed: %v", err)
+			o.code = 500
+			o.data = gondulapi.Report{Error: gondulapi.Errorf(o.code, "Precondition failed: unable to compute the current ETag")}
+			return o, false
+		}
+	}
+	if ifMatch != "" {
+		if !exists || !etagMatch(ifMatch, tag, false) {
+			o.data = gondulapi.Report{Error: gondulapi.Errorf(o.code, "Precondition failed: If-Match does not match the current item")}
+			return o, false
+		}
+	} else if exists && etagMatch(ifNoneMatch, tag, true) {
+		o.data = gondulapi.Report{Error: gondulapi.Errorf(o.code, "Precondition failed: If-None-Match matches the current item")}
+		return o, false
+	}
+	return output{}, true
 }
 
 // get is a badly named function in the context of HTTP since what it
 // really does is just read the body of a HTTP request. In my defence, it
 // used to do more. But what have it done for me lately?!
+//
+// The body is streamed, so chunked bodies work the same as those with a
+// Content-Length, and it is never read past the maximum body size. Bodies
+// sent with Content-Encoding: gzip are decompressed first. Too large
+// bodies are a 413, truncated ones a 400 and bodies for PUT and POST that
+// no codec or content coding can read a 415, all as gondulapi.Error.
 func (rcvr receiver) get(w http.ResponseWriter, r *http.Request) (input, error) {
 	var input input
+	input.ctx = r.Context()
 	input.url = r.URL
 	input.method = r.Method
-	log.Printf("%s %s remote: %v", r.Method, r.URL, r.RemoteAddr)
-
-	if r.ContentLength != 0 {
-		input.data = make([]byte, r.ContentLength)
+	input.contentType = r.Header.Get("Content-Type")
+	input.strict = gondulapi.Config.StrictJSON
+	if rcvr.strict != nil {
+		input.strict = *rcvr.strict
+	}
 
-		if n, err := io.ReadFull(r.Body, input.data); err != nil {
-			log.Printf("Read error from client. Read %d bytes. Remote: %v. error: %s", n, r.RemoteAddr, err)
-			return input, fmt.Errorf("read failed: %v", err)
+	max := rcvr.maxBodySize
+	if max == 0 {
+		max = gondulapi.Config.MaxBodySize
+	}
+	if max == 0 {
+		max = defaultMaxBodySize
+	}
+	if r.ContentLength > max {
+		return input, gondulapi.Errorf(413, "Request body of %d bytes is larger than the maximum of %d bytes", r.ContentLength, max)
+	}
+	body, err := decompress(w, r)
+	if err != nil || body == nil {
+		return input, err
+	}
+	defer body.Close()
+	// The limit applies to the decompressed body, or a small gzip bomb
+	// would get past it.
+	data, err := io.ReadAll(http.MaxBytesReader(w, body, max))
+	if err != nil {
+		log.Context(r.Context()).Printf("Read error from client. Read %d bytes. Remote: %v. error: %s", len(data), r.RemoteAddr, err)
+		var maxerr *http.MaxBytesError
+		if errors.As(err, &maxerr) {
+			return input, gondulapi.Errorf(413, "Request body is larger than the maximum of %d bytes", max)
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return input, gondulapi.Errorf(400, "Request body truncated after %d bytes", len(data))
+		}
+		return input, gondulapi.Errorf(400, "Unable to read request body: %v", err)
+	}
+	if len(data) > 0 {
+		input.data = data
+	}
+	if input.data != nil && (r.Method == "PUT" || r.Method == "POST") {
+		if _, err := decoder(input.contentType); err != nil {
+			return input, err
 		}
 	}
-
 	return input, nil
 }
 
-// message is a convenience function
-func message(str string, v ...interface{}) (m struct {
-	Message string
-	Error   string `json:",omitempty"`
-}) {
-	m.Message = fmt.Sprintf(str, v...)
-	return
+// callGet calls the Get method of item, using whichever variant of Getter
+// it implements. ok is false if it implements neither.
+func callGet(item interface{}, request *gondulapi.Request) (report gondulapi.Report, ok bool, err error) {
+	switch get := item.(type) {
+	case gondulapi.RequestGetter:
+		report, err = get.Get(request)
+	case gondulapi.ContextGetter:
+		report, err = get.Get(request.Context, request.Element)
+	case gondulapi.Getter:
+		report, err = get.Get(request.Element)
+	default:
+		return report, false, nil
+	}
+	return report, true, err
 }
 
 // handle figures out what Method the input has, casts item to the correct
 // interface and calls the relevant function, if any, for that data. For
-// PUT and POST it also parses the input data.
-func handle(item interface{}, input input, path string) (output output) {
+// PUT and POST it also parses the input data, and PATCH is done by patch.
+func (rcvr receiver) handle(item interface{}, input input) (output output) {
+	path := rcvr.path
+	request := gondulapi.Request{Element: input.url.Path[len(path):], Params: input.params, Query: input.query, Context: input.ctx}
 	output.code = 200
 	output.headers = make(map[string]string)
 	var report gondulapi.Report
@@ -130,112 +354,240 @@ func handle(item interface{}, input input, path string) (output output) {
 		if report.Code != 0 {
 			output.code = report.Code
 		} else if havegerr {
-			log.Tracef("During REST defered reply, we got a gondulapi.Error: %v", gerr)
+			log.Context(input.ctx).Tracef("During REST defered reply, we got a gondulapi.Error: %v", gerr)
 			output.code = gerr.Code
 		} else if report.Error != nil {
 			output.code = 500
 		} else {
 			output.code = 200
 		}
-		if output.data == nil && output.code != 204 {
+		// Errors replace whatever was going to be sent, since the
+		// report now has the error in it.
+		if (output.data == nil && output.code != 204) || output.code >= 400 {
 			output.data = report
 		}
 	}()
-	if input.method == "GET" {
-		get, ok := item.(gondulapi.Getter)
+	notAllowed := func() {
+		err = gondulapi.Errorf(405, "%s on %s failed: No such method for this path", input.method, path)
+		output.headers["Allow"] = strings.Join(findInterfaces(item), ", ")
+	}
+	if input.method == "GET" || input.method == "HEAD" {
+		var ok bool
+		report, ok, err = callGet(item, &request)
 		if !ok {
-			output.data = message("%s on %s failed: No such method for this path", input.method, path)
+			notAllowed()
 			return
 		}
-		report, err = get.Get(input.url.Path[len(path):])
 		if err != nil {
-			log.Printf("GET method returned error: %v", err)
+			log.Context(input.ctx).Printf("GET method returned error: %v", err)
 			return
 		}
-		output.data = get
+		output.data = item
+		if report.Fields != nil {
+			output.data, err = sparse(item, report.Fields)
+			if err != nil {
+				log.Context(input.ctx).Printf("Unable to leave out fields: %v", err)
+				err = gondulapi.InternalError
+				return
+			}
+		}
 		output.headers = report.Headers
+		if report.Page != nil {
+			if output.headers == nil {
+				output.headers = make(map[string]string)
+			}
+			if link := links(input.url, report.Page); link != "" {
+				output.headers["Link"] = link
+			}
+		}
 	} else if input.method == "PUT" {
-		err = json.Unmarshal(input.data, &item)
+		err = unmarshal(input, &item)
 		if err != nil {
 			return
-	}
-		put, ok := item.(gondulapi.Putter)
-		if !ok {
-			output.data = message("%s on %s failed: No such method for this path", input.method, path)
+		}
+		if err = validate(item, input.method); err != nil {
+			return
+		}
+		switch put := item.(type) {
+		case gondulapi.RequestPutter:
+			report, err = put.Put(&request)
+		case gondulapi.ContextPutter:
+			report, err = put.Put(request.Context, request.Element)
+		case gondulapi.Putter:
+			report, err = put.Put(request.Element)
+		default:
+			notAllowed()
 			return
 		}
-		report, err = put.Put(input.url.Path[len(path):])
 		output.data = report
 	} else if input.method == "DELETE" {
-		del, ok := item.(gondulapi.Deleter)
-		if !ok {
-			output.data = message("%s on %s failed: No such method for this path", input.method, path)
+		switch del := item.(type) {
+		case gondulapi.RequestDeleter:
+			report, err = del.Delete(&request)
+		case gondulapi.ContextDeleter:
+			report, err = del.Delete(request.Context, request.Element)
+		case gondulapi.Deleter:
+			report, err = del.Delete(request.Element)
+		default:
+			notAllowed()
+			return
+		}
+		output.data = report
+	} else if input.method == "PATCH" {
+		switch item.(type) {
+		case gondulapi.RequestPatcher, gondulapi.ContextPatcher, gondulapi.Patcher:
+		default:
+			notAllowed()
 			return
 		}
-		report, err = del.Delete(input.url.Path[len(path):])
+		report, err = rcvr.patch(item, input, &request)
 		output.data = report
+	} else if input.method == "OPTIONS" {
+		output.headers["Allow"] = strings.Join(findInterfaces(item), ", ")
+		output.data = struct {
+			Methods []string
+			Schema  interface{}
+		}{findInterfaces(item), schema(reflect.Indirect(reflect.ValueOf(item)).Type())}
 	} else if input.method == "POST" {
-		err = json.Unmarshal(input.data, &item)
+		err = unmarshal(input, &item)
 		if err != nil {
 			return
 		}
-		post, ok := item.(gondulapi.Poster)
-		if !ok {
-			output.data = message("%s on %s failed: No such method for this path", input.method, path)
+		if err = validate(item, input.method); err != nil {
+			return
+		}
+		switch post := item.(type) {
+		case gondulapi.ContextPoster:
+			report, err = post.Post(request.Context)
+		case gondulapi.Poster:
+			report, err = post.Post()
+		default:
+			notAllowed()
 			return
 		}
-		report, err = post.Post()
 		output.data = report
+	} else {
+		notAllowed()
 	}
 	return
 }
 
-// checkAuth verifies authentication
-func checkAuth(item interface{}, r *http.Request, rcvr receiver) (output, error) {
-	auth, ok := item.(gondulapi.Auther)
-	if !ok {
-		return output{}, nil
+// checkAuth verifies authentication, through either variant of Auther,
+// and returns who was verified: the subject of a client certificate
+// verified by TLS, or the basic auth user if the method needs
+// credentials, see authMethods. Auth might not look at the password at
+// all for the other methods, e.g. GET with auth.ReadPublic, so their
+// users aren't verified. The subject of a verified client certificate is
+// only available to CredentialAuther.
+func checkAuth(item interface{}, r *http.Request, rcvr receiver) (output, string, error) {
+	subject := ""
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		subject = r.TLS.VerifiedChains[0][0].Subject.String()
 	}
-	var user, pass string
-	if r.Header["Authorization"] == nil || len(r.Header["Authorization"]) < 1 {
-		user = ""
-		pass = ""
-	} else {
-		hdr := r.Header["Authorization"][0]
-		raw := strings.Split(hdr, "Basic ")
-		clean, err := base64.StdEncoding.DecodeString(raw[1])
-		log.Printf("Got auth: %v err: %v", clean, err)
-		up := strings.Split(string(clean), ":")
-		user = up[0]
-		pass = up[1]
+	switch item.(type) {
+	case gondulapi.Auther, gondulapi.CredentialAuther:
+	default:
+		return output{}, subject, nil
+	}
+	// Anything but valid basic auth is treated as no credentials at all,
+	// leaving it to the Auther to refuse.
+	user, pass, ok := r.BasicAuth()
+	if !ok && r.Header.Get("Authorization") != "" {
+		log.Context(r.Context()).Printf("Ignoring invalid Authorization header from %v", r.RemoteAddr)
 	}
 
-	err := auth.Auth(rcvr.path, r.URL.Path[len(rcvr.path):], r.Method, user, pass)
+	var err error
+	element := r.URL.Path[len(rcvr.path):]
+	switch auth := item.(type) {
+	case gondulapi.CredentialAuther:
+		credentials := gondulapi.Credentials{User: user, Password: pass, Subject: subject}
+		err = auth.Auth(rcvr.path, element, r.Method, credentials)
+	case gondulapi.Auther:
+		err = auth.Auth(rcvr.path, element, r.Method, user, pass)
+	}
 	if err != nil {
 		o := output{}
 		o.code = 401
-		o.data = "damn"
-		return o, err
+		o.data = gondulapi.Report{Error: err}
+		return o, "", err
+	}
+	if subject != "" {
+		return output{}, subject, nil
 	}
-	return output{}, nil
+	if rcvr.authMethods[r.Method] {
+		return output{}, user, nil
+	}
+	return output{}, "", nil
+}
+
+// needsAuth finds the methods Auth of rcvr needs credentials for, by
+// asking it once for each, the same way public does for the index.
+func (rcvr receiver) needsAuth() map[string]bool {
+	item := rcvr.alloc()
+	methods := make(map[string]bool)
+	for _, m := range []string{"GET", "HEAD", "PUT", "POST", "PATCH", "DELETE"} {
+		methods[m] = !public(item, rcvr.path, m)
+	}
+	return methods
 }
 
 // ServeHTTP implements the net/http ServeHTTP handler. It does this by
-// first reading input data, then allocating a data structure specified on
-// the receiver originally through AddHandler, then parses input data onto
-// that data and replies. All input/output is valid JSON.
+// first reading input data, then using the data structure allocated by
+// authenticate, specified on the receiver originally through AddHandler,
+// then parses input data onto that data and replies. It is the innermost
+// handler, see Middleware. Input and output is JSON, unless the client asks
+// for something else, see negotiate and decoder.
+//
+// The context of the request is cancelled after the timeout of the
+// handler, if it has one, see Timeout.
 func (rcvr receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	timeout := rcvr.timeout
+	if timeout == 0 {
+		timeout = time.Duration(gondulapi.Config.RequestTimeout) * time.Second
+	}
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
 	input, err := rcvr.get(w, r)
-	pretty := len(input.url.Query()["pretty"]) > 0
-	item := rcvr.alloc()
+	pretty := len(r.URL.Query()["pretty"]) > 0
 	if err != nil {
-		log.Printf("go receiver error: %s", err)
+		log.Context(r.Context()).Printf("go receiver error: %s", err)
+		rcvr.fail(w, r, err, pretty)
+		return
+	}
+	if _, err := negotiate(r); err != nil {
+		rcvr.fail(w, r, err, pretty)
+		return
+	}
+	// POST never addresses an element and OPTIONS describes the handler as
+	// a whole, so neither is held to the pattern.
+	if r.Method != "POST" && r.Method != "OPTIONS" {
+		input.params, err = rcvr.pattern.match(r.URL.Path[len(rcvr.path):])
+		if err != nil {
+			rcvr.fail(w, r, err, pretty)
+			return
+		}
+	}
+	if r.Method == "GET" || r.Method == "HEAD" {
+		input.query, err = parseQuery(r.URL.RawQuery)
+		if err == nil {
+			err = rcvr.paging(r.URL.Query(), &input.query)
+		}
+		if err != nil {
+			rcvr.fail(w, r, err, pretty)
+			return
+		}
+	}
+	item, ok := r.Context().Value(itemKey{}).(interface{})
+	if !ok {
+		item = rcvr.alloc()
 	}
-	if output, err := checkAuth(item, r, rcvr); err != nil {
-		log.Printf("auth error: %s", err)
-		rcvr.answer(w, output, pretty)
+	if output, ok := rcvr.precondition(r, input.params); !ok {
+		rcvr.answer(w, r, output, pretty)
 		return
 	}
-	output := handle(item, input, rcvr.path)
-	rcvr.answer(w, output, pretty)
+	output := rcvr.handle(item, input)
+	rcvr.answer(w, r, output, pretty)
 }
@@ -0,0 +1,123 @@
+/*
+Gondul GO API, receiver panic tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	"github.com/gathering/gondulapi/auth"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+// panicky panics on GET, and on PUT by way of a bad type assertion, like
+// the ones that used to be in db.
+type panicky struct {
+	Name string
+}
+
+func (p *panicky) Get(element string) (gondulapi.Report, error) {
+	panic("panicky is panicking")
+}
+
+func (p *panicky) Put(element string) (gondulapi.Report, error) {
+	var search interface{} = 42
+	_ = search.(string)
+	return gondulapi.Report{}, nil
+}
+
+// private needs basic auth for everything.
+type private struct {
+	auth.Private
+	Name string
+}
+
+func (p *private) Get(element string) (gondulapi.Report, error) {
+	p.Name = element
+	return gondulapi.Report{}, nil
+}
+
+func init() {
+	receiver.AddHandler("/panicky/", func() interface{} { return &panicky{} })
+	receiver.AddHandler("/private/", func() interface{} { return &private{} })
+}
+
+func do(t *testing.T, method string, url string, header string) (int, string, string) {
+	t.Helper()
+	var body io.Reader
+	if method == "PUT" {
+		body = strings.NewReader("{}")
+	}
+	req, _ := http.NewRequest(method, url, body)
+	if header != "" {
+		req.Header.Set("Authorization", header)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, resp.Header.Get("Content-Type"), string(b)
+}
+
+func TestPanics(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	for _, method := range []string{"GET", "PUT"} {
+		code, ct, body := do(t, method, srv.URL+"/panicky/x", "")
+		h.CheckEqual(t, code, 500)
+		h.CheckEqual(t, ct, "application/problem+json")
+		h.CheckEqual(t, strings.HasPrefix(body, `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"Internal Server Error","instance":"/panicky/x","requestId":"`), true)
+	}
+
+	// The server is still standing.
+	code, _, _ := do(t, "GET", srv.URL+"/thing/x", "")
+	h.CheckEqual(t, code, 200)
+}
+
+func TestMalformedAuthorization(t *testing.T) {
+	gondulapi.Config.HTTPUser, gondulapi.Config.HTTPPw = "admin", "secret"
+	defer func() { gondulapi.Config.HTTPUser, gondulapi.Config.HTTPPw = "", "" }()
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	for _, header := range []string{
+		"Bearer abc",
+		"Basic",
+		"Basic ",
+		"Basic !!!not base64!!!",
+		"Basic bm9jb2xvbg==", // "nocolon"
+		"basic",
+		"Basic YWRtaW46", // "admin:"
+	} {
+		code, ct, _ := do(t, "GET", srv.URL+"/private/x", header)
+		h.CheckEqual(t, code, 401)
+		h.CheckEqual(t, ct, "application/problem+json")
+		code, _, _ = do(t, "GET", srv.URL+"/thing/x", header)
+		h.CheckEqual(t, code, 200)
+	}
+	code, _, _ := do(t, "GET", srv.URL+"/private/x", "Basic YWRtaW46c2VjcmV0") // "admin:secret"
+	h.CheckEqual(t, code, 200)
+}
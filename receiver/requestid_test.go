@@ -0,0 +1,87 @@
+/*
+Gondul GO API, receiver request ID tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"bytes"
+	"encoding/json"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/log"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+// traced returns the request ID it was served with, and logs through it.
+type traced struct {
+	ID string
+}
+
+func (tr *traced) Get(request *gondulapi.Request) (gondulapi.Report, error) {
+	tr.ID = log.RequestID(request.Context)
+	log.Context(request.Context).Printf("traced %s", request.Element)
+	return gondulapi.Report{}, nil
+}
+
+func init() {
+	receiver.AddHandler("/traced/", func() interface{} { return &traced{} })
+}
+
+func TestRequestID(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+	var buf bytes.Buffer
+	stdlog.SetOutput(&buf)
+	defer stdlog.SetOutput(os.Stderr)
+
+	get := func(id string) (string, string) {
+		req, _ := http.NewRequest("GET", srv.URL+"/traced/x", nil)
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		h.CheckEqual(t, err, nil)
+		var tr traced
+		h.CheckEqual(t, json.NewDecoder(resp.Body).Decode(&tr), nil)
+		resp.Body.Close()
+		return resp.Header.Get("X-Request-ID"), tr.ID
+	}
+
+	header, seen := get("collector-42")
+	h.CheckEqual(t, header, "collector-42")
+	h.CheckEqual(t, seen, "collector-42")
+	h.CheckEqual(t, strings.Contains(buf.String(), "[collector-42] GET /traced/x"), true)
+	h.CheckEqual(t, strings.Contains(buf.String(), "[collector-42] traced x"), true)
+
+	header, seen = get("")
+	h.CheckEqual(t, len(header), 16)
+	h.CheckEqual(t, seen, header)
+
+	// Anything that could mess up the log is replaced.
+	header, _ = get("evil id")
+	h.CheckNotEqual(t, header, "evil id")
+	h.CheckEqual(t, len(header), 16)
+}
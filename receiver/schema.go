@@ -0,0 +1,138 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schemer is implemented by types that know better than reflection what
+// they look like as JSON, typically those with their own MarshalJSON or
+// MarshalText. It returns the JSON Schema of the type, as used by OPTIONS
+// and the OpenAPI document, and is called on the zero value.
+type Schemer interface {
+	JSONSchema() map[string]interface{}
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	schemerType       = reflect.TypeOf((*Schemer)(nil)).Elem()
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+	unmarshalerType     = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// schema describes what t looks like as JSON, as a JSON Schema. Unless
+// the type is a Schemer, it only covers what encoding/json does with it:
+// types with their own MarshalJSON can be anything, types with
+// MarshalText are strings and pointers can be null.
+func schema(t reflect.Type) map[string]interface{} {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		nullable = true
+		t = t.Elem()
+	}
+	s := make(map[string]interface{})
+	if nullable {
+		s["nullable"] = true
+	}
+	ptr := reflect.PtrTo(t)
+	switch {
+	case ptr.Implements(schemerType):
+		for k, v := range reflect.New(t).Interface().(Schemer).JSONSchema() {
+			s[k] = v
+		}
+		return s
+	case t == timeType:
+		s["type"] = "string"
+		s["format"] = "date-time"
+		return s
+	case t.Implements(marshalerType) || ptr.Implements(marshalerType):
+		return s
+	case t.Implements(textMarshalerType) || ptr.Implements(textMarshalerType):
+		s["type"] = "string"
+		return s
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		s["type"] = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s["type"] = "integer"
+	case reflect.Float32, reflect.Float64:
+		s["type"] = "number"
+	case reflect.String:
+		s["type"] = "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			s["type"] = "string"
+			s["format"] = "byte"
+			break
+		}
+		s["type"] = "array"
+		s["items"] = schema(t.Elem())
+	case reflect.Map:
+		s["type"] = "object"
+		s["additionalProperties"] = schema(t.Elem())
+	case reflect.Struct:
+		s["type"] = "object"
+		s["properties"] = properties(t)
+	}
+	return s
+}
+
+// properties returns the schema of each field of the struct t, by the
+// name encoding/json would use. Embedded structs without a name are
+// flattened, same as encoding/json does.
+func properties(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		ft := field.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if field.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			for k, v := range properties(ft) {
+				props[k] = v
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		props[name] = schema(field.Type)
+	}
+	return props
+}
@@ -0,0 +1,173 @@
+/*
+Gondul GO API, receiver method and schema tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+	"github.com/gathering/gondulapi/types"
+)
+
+// placed is embedded in described, so its fields are described's own.
+type placed struct {
+	Row  int
+	Rack string `json:"rack"`
+}
+
+// level knows better than reflection what it looks like.
+type level int
+
+func (l *level) JSONSchema() map[string]interface{} {
+	return map[string]interface{}{"type": "integer", "minimum": 0}
+}
+
+// described has a bit of everything schema has to describe.
+type described struct {
+	placed
+	Name    string
+	Note    *string
+	When    time.Time
+	Since   *time.Time
+	Raw     []byte
+	Tags    []string
+	Ports   map[string]int
+	IP      types.IP
+	Level   *level
+	Place   *placed
+	Renamed int    `json:"renamed,omitempty"`
+	Secret  string `json:"-"`
+	hidden  int
+}
+
+func (d *described) Get(element string) (gondulapi.Report, error) {
+	d.Name = element
+	return gondulapi.Report{}, nil
+}
+
+func (d *described) Put(element string) (gondulapi.Report, error) {
+	return gondulapi.Report{Ok: 1}, nil
+}
+
+func init() {
+	receiver.AddHandler("/described/", func() interface{} { return &described{} })
+}
+
+func TestHead(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/described/x")
+	h.CheckEqual(t, err, nil)
+	resp.Body.Close()
+	tag := resp.Header.Get("ETag")
+	h.CheckNotEqual(t, tag, "")
+
+	resp, err = http.Head(srv.URL + "/described/x")
+	h.CheckEqual(t, err, nil)
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, len(b), 0)
+	h.CheckEqual(t, resp.Header.Get("ETag"), tag)
+	h.CheckEqual(t, resp.Header.Get("Content-Type"), "application/json")
+}
+
+func TestNotAllowed(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	cases := []struct {
+		path  string
+		allow string
+	}{
+		{"/described/x", "GET, HEAD, PUT, OPTIONS"},
+		{"/queried/", "GET, HEAD, OPTIONS"},
+		{"/patched/x", "GET, HEAD, PATCH, OPTIONS"},
+	}
+	for _, c := range cases {
+		for _, method := range []string{"POST", "PUT", "PATCH", "DELETE", "FROBNICATE"} {
+			if strings.Contains(c.allow, method) {
+				continue
+			}
+			req, _ := http.NewRequest(method, srv.URL+c.path, strings.NewReader("{}"))
+			resp, err := http.DefaultClient.Do(req)
+			h.CheckEqual(t, err, nil)
+			resp.Body.Close()
+			h.CheckEqual(t, resp.StatusCode, 405)
+			h.CheckEqual(t, resp.Header.Get("Allow"), c.allow)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	req, _ := http.NewRequest("OPTIONS", srv.URL+"/described/x", nil)
+	resp, err := http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	defer resp.Body.Close()
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, resp.Header.Get("Allow"), "GET, HEAD, PUT, OPTIONS")
+	var options struct {
+		Methods []string
+		Schema  struct {
+			Type       string
+			Properties map[string]json.RawMessage
+		}
+	}
+	h.CheckEqual(t, json.NewDecoder(resp.Body).Decode(&options), nil)
+	h.CheckEqual(t, strings.Join(options.Methods, ", "), "GET, HEAD, PUT, OPTIONS")
+	h.CheckEqual(t, options.Schema.Type, "object")
+
+	cases := []struct {
+		name   string
+		schema string
+	}{
+		{"Row", `{"type":"integer"}`},
+		{"rack", `{"type":"string"}`},
+		{"Name", `{"type":"string"}`},
+		{"Note", `{"nullable":true,"type":"string"}`},
+		{"When", `{"format":"date-time","type":"string"}`},
+		{"Since", `{"format":"date-time","nullable":true,"type":"string"}`},
+		{"Raw", `{"format":"byte","type":"string"}`},
+		{"Tags", `{"items":{"type":"string"},"type":"array"}`},
+		{"Ports", `{"additionalProperties":{"type":"integer"},"type":"object"}`},
+		{"IP", `{"example":"192.0.2.1/24","format":"ip","type":"string"}`},
+		{"Level", `{"minimum":0,"nullable":true,"type":"integer"}`},
+		{"Place", `{"nullable":true,"properties":{"Row":{"type":"integer"},"rack":{"type":"string"}},"type":"object"}`},
+		{"renamed", `{"type":"integer"}`},
+	}
+	for _, c := range cases {
+		h.CheckEqual(t, string(options.Schema.Properties[c.name]), c.schema)
+	}
+	// Embedded structs are flattened, and ignored and unexported fields
+	// left out, so there is nothing else.
+	h.CheckEqual(t, len(options.Schema.Properties), len(cases))
+}
@@ -0,0 +1,235 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	gapi "github.com/gathering/gondulapi"
+	"github.com/gathering/gondulapi/log"
+	"github.com/gathering/gondulapi/metrics"
+)
+
+// Default timeouts, used when gondulapi.Config doesn't set them.
+const (
+	defaultReadTimeout     = 10 * time.Second
+	defaultWriteTimeout    = 30 * time.Second
+	defaultIdleTimeout     = 120 * time.Second
+	defaultShutdownTimeout = 10 * time.Second
+)
+
+// Server serves the handlers registered with AddHandler. Unlike Start,
+// it can be stopped: Start serves until its context is cancelled, then
+// drains the requests in flight before returning.
+type Server struct {
+	Addr            string        // Address to listen to, e.g. ":8080"
+	ShutdownTimeout time.Duration // How long to wait for requests to finish on shutdown
+	DrainDelay      time.Duration // How long to keep serving with /readyz failing before shutting down
+
+	// HTTPS is served if CertFile is set, see gondulapi.Config.
+	CertFile          string
+	KeyFile           string
+	ClientCAFile      string
+	RequireClientCert bool
+
+	server   *http.Server
+	draining atomic.Bool
+}
+
+// seconds returns s seconds as a duration, or def if s is 0.
+func seconds(s int, def time.Duration) time.Duration {
+	if s == 0 {
+		return def
+	}
+	return time.Duration(s) * time.Second
+}
+
+// NewServer sets up a Server for all handlers registered so far, using
+// gondulapi.Config for the address and timeouts. Handlers added after
+// this are not served by it. Invalid handler patterns are fatal, since
+// they are programming errors.
+func NewServer() *Server {
+	s := Server{}
+	s.Addr = gapi.Config.ListenAddress
+	if s.Addr == "" {
+		log.Printf("No listenaddress configured, using default :8080")
+		s.Addr = ":8080"
+	}
+	s.ShutdownTimeout = seconds(gapi.Config.ShutdownTimeout, defaultShutdownTimeout)
+	s.DrainDelay = seconds(gapi.Config.DrainDelay, 0)
+	s.CertFile = gapi.Config.TLSCertFile
+	s.KeyFile = gapi.Config.TLSKeyFile
+	s.ClientCAFile = gapi.Config.TLSClientCAFile
+	s.RequireClientCert = gapi.Config.TLSRequireClient
+	s.server = &http.Server{
+		Handler:      s.mux(),
+		ReadTimeout:  seconds(gapi.Config.ReadTimeout, defaultReadTimeout),
+		WriteTimeout: seconds(gapi.Config.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:  seconds(gapi.Config.IdleTimeout, defaultIdleTimeout),
+	}
+	return &s
+}
+
+// mux builds the http.Handler for all registered handlers.
+func (s *Server) mux() http.Handler {
+	serveMux := http.NewServeMux()
+	if gapi.Config.Prefix != "" {
+		log.Tracef("Prefixing URLs with %s", gapi.Config.Prefix)
+	}
+	rcvrs := make([]receiver, 0, len(handles))
+	bases := make(map[string]string)
+	for idx, rcvr := range handles {
+		target := fmt.Sprintf("%s%s", gapi.Config.Prefix, idx)
+		p, err := parsePattern(target)
+		if err != nil {
+			log.Fatalf("Invalid handler pattern: %v", err)
+		}
+		if other, ok := bases[p.base]; ok {
+			log.Fatalf("Invalid handler pattern: %s and %s are both served from %s", other, target, p.base)
+		}
+		bases[p.base] = target
+		if err := checkTags(reflect.TypeOf(rcvr.alloc())); err != nil {
+			log.Fatalf("Invalid handler for %s: %v", target, err)
+		}
+		methods := strings.Join(findInterfaces(rcvr.alloc()), " ")
+		log.Printf("Listening for %v (%T) - %s\n", target, rcvr.alloc(), methods)
+		rcvr.path = p.base
+		rcvr.pattern = p
+		serveMux.Handle(p.base, rcvr.handler())
+		rcvrs = append(rcvrs, rcvr)
+	}
+	sort.Slice(rcvrs, func(i, j int) bool { return rcvrs[i].pattern.raw < rcvrs[j].pattern.raw })
+	c := newComponents()
+	if gapi.Config.OpenAPIPath != "" {
+		log.Printf("Serving the OpenAPI document on %s", gapi.Config.OpenAPIPath)
+		serveMux.Handle(gapi.Config.OpenAPIPath, openAPIHandler(openAPI(rcvrs, c)))
+	}
+	// The built-in paths are left to handlers registered on them.
+	taken := make(map[string]bool)
+	for _, rcvr := range rcvrs {
+		taken[rcvr.path] = true
+	}
+	root := strings.TrimSuffix(gapi.Config.Prefix, "/") + "/"
+	if !taken[root] && !gapi.Config.DisableIndex {
+		serveMux.Handle(root, indexHandler(root, apiIndex(rcvrs, c, gapi.Config.OpenAPIPath)))
+	}
+	if !taken[healthPath] {
+		serveMux.Handle(healthPath, s.healthHandler(false))
+	}
+	if !taken[readyPath] {
+		serveMux.Handle(readyPath, s.healthHandler(true))
+	}
+	if gapi.Config.MetricsPath != "" {
+		log.Printf("Serving metrics on %s", gapi.Config.MetricsPath)
+		serveMux.Handle(gapi.Config.MetricsPath, metrics.Handler())
+	}
+	return serveMux
+}
+
+// Handler returns the http.Handler serving the registered handlers, e.g.
+// for use with net/http/httptest.
+func (s *Server) Handler() http.Handler {
+	return s.server.Handler
+}
+
+// Start listens to s.Addr and serves requests until ctx is cancelled, at
+// which point it shuts down as Shutdown does. It returns nil after a
+// clean shutdown.
+func (s *Server) Start(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen to %s: %w", s.Addr, err)
+	}
+	return s.Serve(ctx, l)
+}
+
+// Serve is Start with an existing listener, which is closed on return. If
+// Shutdown is called directly, Serve returns right away, while Shutdown
+// waits for the requests in flight.
+//
+// If CertFile is set, it serves HTTPS, reloading the certificate, key and
+// client CAs whenever they change on disk. RequireClientCert without both
+// CertFile and ClientCAFile is an error, rather than serving without it.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	if s.RequireClientCert && (s.CertFile == "" || s.ClientCAFile == "") {
+		l.Close()
+		return fmt.Errorf("unable to require client certificates without both a TLS certificate and a client CA (TLSCertFile and TLSClientCAFile)")
+	}
+	if s.CertFile != "" {
+		c, err := newCerts(s.CertFile, s.KeyFile, s.ClientCAFile)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		l = tls.NewListener(l, c.tlsConfig(s.RequireClientCert))
+		log.Printf("Starting HTTPS receiver on %s", l.Addr())
+	} else {
+		log.Printf("Starting HTTP receiver on %s", l.Addr())
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.server.Serve(l)
+	}()
+	select {
+	case err := <-errs:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+	}
+	log.Printf("Shutting down HTTP receiver on %s", l.Addr())
+	sctx, cancel := context.WithTimeout(context.Background(), s.DrainDelay+s.ShutdownTimeout)
+	defer cancel()
+	return s.Shutdown(sctx)
+}
+
+// Shutdown stops accepting new requests and waits for those in flight to
+// finish, or for ctx to expire, whichever comes first. Connections still
+// active when ctx expires are closed, and ctx's error is returned.
+//
+// Before that, /readyz fails for DrainDelay while requests are still
+// served, giving load balancers time to stop sending more.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+	if s.DrainDelay > 0 {
+		log.Printf("Draining for %v before shutting down", s.DrainDelay)
+		select {
+		case <-time.After(s.DrainDelay):
+		case <-ctx.Done():
+		}
+	}
+	err := s.server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Requests still in flight after shutdown timeout, closing: %v", err)
+		s.server.Close()
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+/*
+Gondul GO API, receiver server tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+// slow blocks GETs until release is closed, after telling entered.
+type slow struct {
+	Name string
+}
+
+var (
+	entered = make(chan bool, 1)
+	release = make(chan bool)
+)
+
+func (s *slow) Get(element string) (gondulapi.Report, error) {
+	entered <- true
+	<-release
+	s.Name = element
+	return gondulapi.Report{}, nil
+}
+
+// thing is a plain GET/PUT object.
+type thing struct {
+	Name string
+}
+
+func (t *thing) Get(element string) (gondulapi.Report, error) {
+	t.Name = element
+	return gondulapi.Report{}, nil
+}
+
+func (t *thing) Put(element string) (gondulapi.Report, error) {
+	return gondulapi.Report{Ok: 1}, nil
+}
+
+func init() {
+	receiver.AddHandler("/slow/", func() interface{} { return &slow{} })
+	receiver.AddHandler("/thing/", func() interface{} { return &thing{} })
+}
+
+func TestShutdownDrains(t *testing.T) {
+	release = make(chan bool)
+	s := receiver.NewServer()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	h.CheckEqual(t, err, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- s.Serve(ctx, l)
+	}()
+
+	replied := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String() + "/slow/x")
+		if err != nil {
+			replied <- err.Error()
+			return
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		replied <- string(b)
+	}()
+	<-entered
+	cancel()
+	select {
+	case <-served:
+		t.Fatalf("Serve returned with a request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, err = net.Dial("tcp", l.Addr().String())
+	h.CheckNotEqual(t, err, nil)
+
+	close(release)
+	h.CheckEqual(t, <-replied, "{\"Name\":\"x\"}\n")
+	h.CheckEqual(t, <-served, nil)
+}
+
+func TestMethods(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	resp, err := http.Head(srv.URL + "/thing/x")
+	h.CheckEqual(t, err, nil)
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, len(b), 0)
+	h.CheckNotEqual(t, resp.Header.Get("ETag"), "")
+
+	req, _ := http.NewRequest("DELETE", srv.URL+"/thing/x", nil)
+	resp, err = http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	resp.Body.Close()
+	h.CheckEqual(t, resp.StatusCode, 405)
+	h.CheckEqual(t, resp.Header.Get("Allow"), "GET, HEAD, PUT, OPTIONS")
+
+	req, _ = http.NewRequest("OPTIONS", srv.URL+"/thing/x", nil)
+	resp, err = http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	b, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, strings.Contains(string(b), `"Name":{"type":"string"}`), true)
+
+	req, _ = http.NewRequest("PUT", srv.URL+"/thing/x", strings.NewReader(`{"Name": "x"}`))
+	req.ContentLength = -1
+	resp, err = http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	b, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, string(b), "{\"Ok\":1}\n")
+
+	req, _ = http.NewRequest("GET", srv.URL+"/thing/x", nil)
+	req.Header.Set("Accept", "application/yaml")
+	resp, err = http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	b, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	h.CheckEqual(t, resp.Header.Get("Content-Type"), "application/yaml")
+	h.CheckEqual(t, string(b), "Name: x\n")
+}
+
+func TestMetrics(t *testing.T) {
+	gondulapi.Config.MetricsPath = "/metrics"
+	defer func() { gondulapi.Config.MetricsPath = "" }()
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/thing/metrics")
+	h.CheckEqual(t, err, nil)
+	resp.Body.Close()
+	req, _ := http.NewRequest("FROBNICATE", srv.URL+"/thing/metrics", nil)
+	resp, err = http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	resp.Body.Close()
+	resp, err = http.Get(srv.URL + "/metrics")
+	h.CheckEqual(t, err, nil)
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, strings.Contains(string(b), "\ngondulapi_http_requests_total{path=\"/thing/\",method=\"GET\",code=\"200\"} "), true)
+	h.CheckEqual(t, strings.Contains(string(b), "\ngondulapi_http_request_duration_seconds_count{path=\"/thing/\",method=\"GET\"} "), true)
+	h.CheckEqual(t, strings.Contains(string(b), "\ngondulapi_http_requests_total{path=\"/thing/\",method=\"other\",code=\"405\"} "), true)
+	h.CheckEqual(t, strings.Contains(string(b), "FROBNICATE"), false)
+}
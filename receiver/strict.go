@@ -0,0 +1,198 @@
+/*
+Gondul GO API, strict JSON decoding
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gathering/gondulapi"
+)
+
+// strictUnmarshal is json.Unmarshal for handlers in strict mode, see
+// StrictJSON. Before decoding data onto v it checks that every key names
+// a field of v exactly, case included, that no key appears twice in the
+// same object and that nothing follows the document. encoding/json
+// happily ignores all three, so a typo in a PUT is silently lost.
+func strictUnmarshal(data []byte, v interface{}) error {
+	if err := strict(data, v); err != nil {
+		return err
+	}
+	return jsonError(json.Unmarshal(data, v))
+}
+
+// strict does the checks of strictUnmarshal, reporting everything it
+// finds as a 400 with one FieldError each. Syntax errors are left to
+// json.Unmarshal, which says it better.
+func strict(data []byte, v interface{}) error {
+	s := scanner{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	s.dec.UseNumber()
+	if err := s.walk(target(v), ""); err != nil {
+		return nil
+	}
+	if offset := s.skip(s.dec.InputOffset(), " \t\r\n"); offset < int64(len(data)) {
+		s.failed = append(s.failed, gondulapi.FieldError{
+			Message: fmt.Sprintf("Unexpected data after the JSON document at offset %d", offset),
+			Offset:  offset,
+		})
+	}
+	if len(s.failed) > 0 {
+		return gondulapi.Errori(400, s.failed)
+	}
+	return nil
+}
+
+// target is the type v is decoded as, looking through the pointers and
+// interfaces around it.
+func target(v interface{}) reflect.Type {
+	rv := reflect.ValueOf(v)
+	for (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface) && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	return rv.Type()
+}
+
+// scanner walks the tokens of a JSON document alongside the type it is
+// decoded as.
+type scanner struct {
+	data   []byte
+	dec    *json.Decoder
+	failed gondulapi.FieldErrors
+}
+
+// skip returns the offset of the first byte at or after offset that isn't
+// one of chars.
+func (s *scanner) skip(offset int64, chars string) int64 {
+	for offset < int64(len(s.data)) && strings.IndexByte(chars, s.data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// walk checks the next value of the document, which is decoded as t and
+// found at path. A nil t is anything, so only duplicates are looked for.
+func (s *scanner) walk(t reflect.Type, path string) error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return err
+	}
+	t = concrete(t)
+	switch tok {
+	case json.Delim('{'):
+		var fields map[string]reflect.Type
+		if t != nil && t.Kind() == reflect.Struct {
+			fields = make(map[string]reflect.Type)
+			members(t, fields)
+		}
+		seen := make(map[string]bool)
+		for s.dec.More() {
+			offset := s.skip(s.dec.InputOffset(), " \t\r\n,")
+			tok, err := s.dec.Token()
+			if err != nil {
+				return err
+			}
+			key := tok.(string)
+			name := key
+			if path != "" {
+				name = path + "." + key
+			}
+			var elem reflect.Type
+			switch {
+			case seen[key]:
+				s.failed = append(s.failed, gondulapi.FieldError{Field: name, Message: "appears more than once", Offset: offset})
+			case fields != nil:
+				var ok bool
+				if elem, ok = fields[key]; !ok {
+					s.failed = append(s.failed, gondulapi.FieldError{Field: name, Message: "is not a known field", Offset: offset})
+				}
+			case t != nil && t.Kind() == reflect.Map:
+				elem = t.Elem()
+			}
+			seen[key] = true
+			if err := s.walk(elem, name); err != nil {
+				return err
+			}
+		}
+		_, err = s.dec.Token()
+	case json.Delim('['):
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		for idx := 0; s.dec.More(); idx++ {
+			if err := s.walk(elem, fmt.Sprintf("%s[%d]", path, idx)); err != nil {
+				return err
+			}
+		}
+		_, err = s.dec.Token()
+	}
+	return err
+}
+
+// concrete returns t without pointers, or nil if any JSON would do for it:
+// interfaces, and types that decode themselves.
+func concrete(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() == reflect.Interface || opaque(t) ||
+		reflect.PtrTo(t).Implements(unmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return nil
+	}
+	return t
+}
+
+// members adds the fields of the struct t to fields by their JSON name,
+// along with those of embedded structs the way encoding/json flattens
+// them.
+func members(t reflect.Type, fields map[string]reflect.Type) {
+	embedded := make([]reflect.Type, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+		name := jsonName(field)
+		if !field.IsExported() || name == "" {
+			continue
+		}
+		fields[name] = field.Type
+	}
+	// Fields of the struct itself hide those of embedded ones.
+	for _, et := range embedded {
+		inner := make(map[string]reflect.Type)
+		members(et, inner)
+		for name, ft := range inner {
+			if _, ok := fields[name]; !ok {
+				fields[name] = ft
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Gondul GO API, strict JSON decoding tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+	"github.com/gathering/gondulapi/types"
+)
+
+type placement struct {
+	Row int
+}
+
+// picky is a switch of sorts, with a bit of everything strict mode has
+// to look through.
+type picky struct {
+	placement
+	Sysname string
+	MgmtIP4 *types.IP
+	Vlan    int `json:"vlan"`
+	Tags    map[string]string
+	Ports   []port
+}
+
+func (p *picky) Get(element string) (gondulapi.Report, error) {
+	p.Sysname = element
+	return gondulapi.Report{}, nil
+}
+
+func (p *picky) Put(element string) (gondulapi.Report, error) {
+	return gondulapi.Report{Ok: 1}, nil
+}
+
+func (p *picky) Patch(element string, fields []string) (gondulapi.Report, error) {
+	return gondulapi.Report{Ok: 1}, nil
+}
+
+func init() {
+	alloc := func() interface{} { return &picky{} }
+	receiver.AddHandler("/picky/", alloc, receiver.StrictJSON(true))
+	receiver.AddHandler("/lenient/", alloc)
+	receiver.AddHandler("/lax/", alloc, receiver.StrictJSON(false))
+}
+
+// status sends body to url with method and returns the status code.
+func status(t *testing.T, method string, url string, contentType string, body string) int {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestStrictJSON(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	good := `{"Sysname": "e1-1", "MgmtIP4": "192.0.2.1/24", "vlan": 10, "Row": 1, "Tags": {"Any": "thing"}, "Ports": [{"Name": "ge-0/0/0"}]}`
+	h.CheckEqual(t, status(t, "PUT", srv.URL+"/picky/e1-1", "application/json", good), 200)
+
+	p := problem(t, "PUT", srv.URL+"/picky/e1-1", `{"Sysname": "e1-1", "MgmtIp4": "192.0.2.1/24"}`)
+	h.CheckEqual(t, p.Status, 400)
+	h.CheckEqual(t, len(p.Errors), 1)
+	h.CheckEqual(t, p.Errors[0].Field, "MgmtIp4")
+	h.CheckEqual(t, p.Errors[0].Message, "is not a known field")
+	h.CheckEqual(t, p.Errors[0].Offset, int64(20))
+
+	p = problem(t, "PUT", srv.URL+"/picky/e1-1", `{"vlan": 1, "Ports": [{"Name": "a"}, {"Nmae": "b"}], "vlan": 2}`)
+	h.CheckEqual(t, p.Status, 400)
+	h.CheckEqual(t, len(p.Errors), 2)
+	h.CheckEqual(t, p.Errors[0].Field, "Ports[1].Nmae")
+	h.CheckEqual(t, p.Errors[0].Message, "is not a known field")
+	h.CheckEqual(t, p.Errors[1].Field, "vlan")
+	h.CheckEqual(t, p.Errors[1].Message, "appears more than once")
+
+	p = problem(t, "PUT", srv.URL+"/picky/e1-1", `{"vlan": 1} {"vlan": 2}`)
+	h.CheckEqual(t, p.Status, 400)
+	h.CheckEqual(t, p.Errors[0].Offset, int64(12))
+	h.CheckEqual(t, p.Detail, "Unexpected data after the JSON document at offset 12")
+
+	h.CheckEqual(t, status(t, "PATCH", srv.URL+"/picky/e1-1", "application/json-patch+json", `[{"op": "add", "path": "/Colour", "value": "red"}]`), 400)
+	h.CheckEqual(t, status(t, "PATCH", srv.URL+"/picky/e1-1", "application/merge-patch+json", `{"vlan": 3}`), 200)
+	h.CheckEqual(t, status(t, "PATCH", srv.URL+"/picky/e1-1", "application/merge-patch+json", `{"Vlan": 3}`), 400)
+
+	// Other formats, and handlers that aren't strict, are as forgiving
+	// as encoding/json.
+	h.CheckEqual(t, status(t, "PUT", srv.URL+"/picky/e1-1", "application/yaml", "MgmtIp4: 192.0.2.1/24\n"), 200)
+	h.CheckEqual(t, status(t, "PUT", srv.URL+"/lenient/e1-1", "application/json", `{"MgmtIp4": "192.0.2.1/24", "vlan": 1, "vlan": 2}`), 200)
+
+	gondulapi.Config.StrictJSON = true
+	defer func() { gondulapi.Config.StrictJSON = false }()
+	h.CheckEqual(t, status(t, "PUT", srv.URL+"/lenient/e1-1", "application/json", `{"MgmtIp4": "192.0.2.1/24"}`), 400)
+	h.CheckEqual(t, status(t, "PUT", srv.URL+"/lax/e1-1", "application/json", `{"MgmtIp4": "192.0.2.1/24"}`), 200)
+}
@@ -0,0 +1,152 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gathering/gondulapi/log"
+)
+
+// certs holds the certificate and client CAs for TLS, and reloads them
+// when the files change on disk. The files are checked on every
+// handshake, which is just a few stats. If reloading fails, the old ones
+// are kept, so a half-written file doesn't take the server down.
+type certs struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu       sync.Mutex
+	modified map[string]time.Time
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+}
+
+// newCerts loads the files for the first time. Unlike later reloads, a
+// failure here is returned.
+func newCerts(certFile string, keyFile string, caFile string) (*certs, error) {
+	c := certs{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// files lists the files in use, skipping caFile if it isn't set.
+func (c *certs) files() []string {
+	files := []string{c.certFile, c.keyFile}
+	if c.caFile != "" {
+		files = append(files, c.caFile)
+	}
+	return files
+}
+
+// changed checks if any of the files have been modified since they were
+// loaded.
+func (c *certs) changed() bool {
+	for _, file := range c.files() {
+		fi, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if !fi.ModTime().Equal(c.modified[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// load (re)reads all the files. It must be called with mu held, or before
+// c is shared.
+func (c *certs) load() error {
+	modified := make(map[string]time.Time)
+	for _, file := range c.files() {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("unable to read TLS file: %w", err)
+		}
+		modified[file] = fi.ModTime()
+	}
+	// Even if the files are broken, there's no point in trying again
+	// until they change.
+	c.modified = modified
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load TLS certificate %s: %w", c.certFile, err)
+	}
+	var pool *x509.CertPool
+	if c.caFile != "" {
+		pem, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return fmt.Errorf("unable to read TLS client CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in TLS client CA %s", c.caFile)
+		}
+	}
+	c.cert = &cert
+	c.pool = pool
+	return nil
+}
+
+// current returns the certificate and client CAs to use, reloading them
+// first if they have changed.
+func (c *certs) current() (*tls.Certificate, *x509.CertPool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.changed() {
+		if err := c.load(); err != nil {
+			log.Printf("Reloading TLS certificates failed, keeping the old ones: %v", err)
+		} else {
+			log.Printf("Reloaded TLS certificate %s", c.certFile)
+		}
+	}
+	return c.cert, c.pool
+}
+
+// tlsConfig returns the tls.Config for the server. Each handshake gets a
+// copy with the current certificate and client CAs. With client CAs,
+// certificates are verified if given, or required if requireClient is
+// set, and the subject is passed on to CredentialAuther.
+func (c *certs) tlsConfig(requireClient bool) *tls.Config {
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.caFile != "" {
+		base.ClientAuth = tls.VerifyClientCertIfGiven
+		if requireClient {
+			base.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, pool := c.current()
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.Certificates = []tls.Certificate{*cert}
+		cfg.ClientCAs = pool
+		return cfg, nil
+	}
+	return base
+}
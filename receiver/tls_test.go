@@ -0,0 +1,183 @@
+/*
+Gondul GO API, receiver TLS tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+)
+
+// whoami replies with the subject of the client certificate.
+type whoami struct {
+	Subject string
+}
+
+func (w *whoami) Auth(basepath string, element string, method string, credentials gondulapi.Credentials) error {
+	if credentials.Subject == "" {
+		return gondulapi.Errorf(401, "No client certificate")
+	}
+	w.Subject = credentials.Subject
+	return nil
+}
+
+func (w *whoami) Get(element string) (gondulapi.Report, error) {
+	return gondulapi.Report{}, nil
+}
+
+func init() {
+	receiver.AddHandler("/whoami/", func() interface{} { return &whoami{} })
+}
+
+// mkcert makes a certificate signed by parent, or a self-signed CA if
+// parent is nil, and writes it and its key as PEM to dir/name.pem and
+// dir/name.key.
+func mkcert(t *testing.T, dir string, name string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	h.CheckEqual(t, err, nil)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	h.CheckEqual(t, err, nil)
+	cert, err := x509.ParseCertificate(der)
+	h.CheckEqual(t, err, nil)
+	kder, err := x509.MarshalECPrivateKey(key)
+	h.CheckEqual(t, err, nil)
+	err = os.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	h.CheckEqual(t, err, nil)
+	err = os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0600)
+	h.CheckEqual(t, err, nil)
+	return cert, key
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := mkcert(t, dir, "ca", 1, nil, nil)
+	mkcert(t, dir, "server", 2, ca, caKey)
+	mkcert(t, dir, "provisioner", 3, ca, caKey)
+
+	s := receiver.NewServer()
+	s.CertFile = filepath.Join(dir, "server.pem")
+	s.KeyFile = filepath.Join(dir, "server.key")
+	s.ClientCAFile = filepath.Join(dir, "ca.pem")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	h.CheckEqual(t, err, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx, l)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	client, err := tls.LoadX509KeyPair(filepath.Join(dir, "provisioner.pem"), filepath.Join(dir, "provisioner.key"))
+	h.CheckEqual(t, err, nil)
+	get := func(certs []tls.Certificate) (*http.Response, string) {
+		t.Helper()
+		c := http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+			DisableKeepAlives: true,
+		}}
+		resp, err := c.Get("https://" + l.Addr().String() + "/whoami/")
+		h.CheckEqual(t, err, nil)
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(b)
+	}
+
+	resp, body := get([]tls.Certificate{client})
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, body, "{\"Subject\":\"CN=provisioner\"}\n")
+	h.CheckEqual(t, resp.TLS.PeerCertificates[0].SerialNumber.Int64(), int64(2))
+
+	resp, _ = get(nil)
+	h.CheckEqual(t, resp.StatusCode, 401)
+
+	// Replace the server certificate, and make sure the change is seen
+	// even on file systems with coarse timestamps.
+	mkcert(t, dir, "server", 4, ca, caKey)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(s.CertFile, later, later)
+	os.Chtimes(s.KeyFile, later, later)
+	resp, _ = get([]tls.Certificate{client})
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, resp.TLS.PeerCertificates[0].SerialNumber.Int64(), int64(4))
+
+	// A broken certificate is ignored, keeping the old one.
+	os.WriteFile(s.CertFile, []byte("garbage"), 0600)
+	resp, _ = get([]tls.Certificate{client})
+	h.CheckEqual(t, resp.StatusCode, 200)
+	h.CheckEqual(t, resp.TLS.PeerCertificates[0].SerialNumber.Int64(), int64(4))
+}
+
+func TestTLSRequireClient(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := mkcert(t, dir, "ca", 1, nil, nil)
+	mkcert(t, dir, "server", 2, ca, caKey)
+
+	// Requiring a client certificate without a client CA, or without
+	// TLS at all, fails instead of letting everyone in.
+	for _, caFile := range []string{"", filepath.Join(dir, "ca.pem")} {
+		for _, certFile := range []string{"", filepath.Join(dir, "server.pem")} {
+			if caFile != "" && certFile != "" {
+				continue
+			}
+			s := receiver.NewServer()
+			s.CertFile = certFile
+			s.KeyFile = filepath.Join(dir, "server.key")
+			s.ClientCAFile = caFile
+			s.RequireClientCert = true
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			h.CheckEqual(t, err, nil)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			h.CheckNotEqual(t, s.Serve(ctx, l), nil)
+			cancel()
+		}
+	}
+}
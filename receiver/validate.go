@@ -0,0 +1,294 @@
+/*
+Gondul GO API, http receiver code
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gathering/gondulapi"
+)
+
+// rule is a single rule from a validate tag, e.g. "max=64".
+type rule struct {
+	name string
+	arg  string
+}
+
+// rules parses a validate tag, e.g. `validate:"required,max=64"`. The
+// values of oneof are separated by spaces, e.g. "oneof=OK WARN FAIL".
+func rules(tag string) ([]rule, error) {
+	if tag == "" {
+		return nil, nil
+	}
+	list := make([]rule, 0)
+	for _, part := range strings.Split(tag, ",") {
+		name, arg, hasArg := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "required", "ip", "ip4", "ip6":
+			if hasArg {
+				return nil, fmt.Errorf("%s takes no argument, got %q", name, part)
+			}
+		case "min", "max":
+			if _, err := strconv.ParseFloat(arg, 64); err != nil {
+				return nil, fmt.Errorf("%s needs a number, got %q", name, part)
+			}
+		case "oneof":
+			if len(strings.Fields(arg)) == 0 {
+				return nil, fmt.Errorf("oneof needs at least one value")
+			}
+		default:
+			return nil, fmt.Errorf("unknown rule %q", name)
+		}
+		list = append(list, rule{name, arg})
+	}
+	return list, nil
+}
+
+// opaque checks if t is a type validation doesn't look inside, because it
+// has its own JSON representation.
+func opaque(t reflect.Type) bool {
+	ptr := reflect.PtrTo(t)
+	return t == timeType ||
+		t.Implements(marshalerType) || ptr.Implements(marshalerType) ||
+		t.Implements(textMarshalerType) || ptr.Implements(textMarshalerType)
+}
+
+// checkTags verifies the validate tags of t and the types in it, so
+// mistakes are found when the handler is registered and not on the first
+// request.
+func checkTags(t reflect.Type) error {
+	return walkTypes(t, make(map[reflect.Type]bool))
+}
+
+func walkTypes(t reflect.Type, seen map[reflect.Type]bool) error {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || opaque(t) || seen[t] {
+		return nil
+	}
+	seen[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if _, err := rules(field.Tag.Get("validate")); err != nil {
+			return fmt.Errorf("invalid validate tag on %s.%s: %v", t.Name(), field.Name, err)
+		}
+		if err := walkTypes(field.Type, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks item against the validate tags on its fields, and then
+// with Validate if it is a gondulapi.Validator. Failed fields are
+// returned as a 422 listing all of them.
+func validate(item interface{}, method string) error {
+	var failed gondulapi.FieldErrors
+	walkValue(reflect.ValueOf(item), "", &failed)
+	if len(failed) == 0 {
+		if v, ok := item.(gondulapi.Validator); ok {
+			err := v.Validate(method)
+			if !errors.As(err, &failed) {
+				return err
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return gondulapi.Errori(422, failed)
+	}
+	return nil
+}
+
+// jsonName returns the name encoding/json uses for field, or "" if it is
+// left out.
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
+// walkValue validates the fields of v and of any structs in it, adding
+// those that fail to failed. path is the JSON path of v.
+func walkValue(v reflect.Value, path string, failed *gondulapi.FieldErrors) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if opaque(v.Type()) {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			walkValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), failed)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			walkValue(iter.Value(), fmt.Sprintf("%s[%v]", path, iter.Key()), failed)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Anonymous && field.Tag.Get("json") == "" {
+				walkValue(v.Field(i), path, failed)
+				continue
+			}
+			name := jsonName(field)
+			if !field.IsExported() || name == "" {
+				continue
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			list, _ := rules(field.Tag.Get("validate"))
+			if msg := check(v.Field(i), list); msg != "" {
+				*failed = append(*failed, gondulapi.FieldError{Field: name, Message: msg})
+				continue
+			}
+			walkValue(v.Field(i), name, failed)
+		}
+	}
+}
+
+// check applies list to v, returning what is wrong with it, if anything.
+// Pointers that are nil are only checked by required, since they are
+// left out.
+func check(v reflect.Value, list []rule) string {
+	for _, r := range list {
+		if r.name == "required" && (v.IsZero() || (v.Kind() == reflect.Ptr && v.Elem().IsZero())) {
+			return "is required"
+		}
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	for _, r := range list {
+		if msg := apply(v, r); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+// text returns v as a string, for the rules working on strings: either
+// a string or something that marshals to text, like types.IP.
+func text(v reflect.Value) (string, bool) {
+	if v.Kind() == reflect.String {
+		return v.String(), true
+	}
+	if !v.CanAddr() {
+		cp := reflect.New(v.Type()).Elem()
+		cp.Set(v)
+		v = cp
+	}
+	if m, ok := v.Addr().Interface().(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		return string(b), err == nil
+	}
+	return "", false
+}
+
+// apply applies a single rule, other than required, to v.
+func apply(v reflect.Value, r rule) string {
+	switch r.name {
+	case "min", "max":
+		limit, _ := strconv.ParseFloat(r.arg, 64)
+		var n float64
+		unit := ""
+		switch v.Kind() {
+		case reflect.String:
+			n, unit = float64(utf8.RuneCountInString(v.String())), " characters"
+		case reflect.Slice, reflect.Array, reflect.Map:
+			n, unit = float64(v.Len()), " elements"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			n = v.Float()
+		default:
+			return ""
+		}
+		if r.name == "min" && n < limit {
+			if unit != "" {
+				return fmt.Sprintf("must be at least %s%s long", r.arg, unit)
+			}
+			return fmt.Sprintf("must be at least %s", r.arg)
+		}
+		if r.name == "max" && n > limit {
+			if unit != "" {
+				return fmt.Sprintf("must be at most %s%s long", r.arg, unit)
+			}
+			return fmt.Sprintf("must be at most %s", r.arg)
+		}
+	case "oneof":
+		values := strings.Fields(r.arg)
+		s, ok := text(v)
+		if !ok {
+			s = fmt.Sprint(v.Interface())
+		}
+		for _, value := range values {
+			if s == value {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %s", strings.Join(values, ", "))
+	case "ip", "ip4", "ip6":
+		s, ok := text(v)
+		if !ok {
+			return ""
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			var prefix netip.Prefix
+			prefix, err = netip.ParsePrefix(s)
+			addr = prefix.Addr()
+		}
+		switch {
+		case err != nil:
+			return "must be an IP address"
+		case r.name == "ip4" && !addr.Is4():
+			return "must be an IPv4 address"
+		case r.name == "ip6" && (!addr.Is6() || addr.Is4In6()):
+			return "must be an IPv6 address"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+/*
+Gondul GO API, receiver validation tests
+Copyright 2020, Kristian Lyngstøl <kly@kly.no>
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package receiver_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gathering/gondulapi"
+	h "github.com/gathering/gondulapi/helper"
+	"github.com/gathering/gondulapi/receiver"
+	"github.com/gathering/gondulapi/types"
+)
+
+// port is validated as part of checked.
+type port struct {
+	Name string `validate:"required,max=8"`
+}
+
+// checked has a rule or two on most fields, and requires Low <= High.
+type checked struct {
+	Name   *string   `validate:"required,min=1,max=64"`
+	Status string    `validate:"oneof=OK WARN FAIL"`
+	Addr   *types.IP `validate:"ip4"`
+	Vlan   int       `json:"vlan" validate:"min=1,max=4094"`
+	Low    int
+	High   int
+	Ports  []port
+}
+
+func (c *checked) Validate(method string) error {
+	if c.Low > c.High {
+		return gondulapi.FieldErrors{{Field: "Low", Message: "must not be above High"}}
+	}
+	return nil
+}
+
+func (c *checked) Put(element string) (gondulapi.Report, error) {
+	return gondulapi.Report{Ok: 1}, nil
+}
+
+func init() {
+	receiver.AddHandler("/checked/", func() interface{} { return &checked{} })
+}
+
+// put sends body to url and returns the status code, and the fields
+// that failed validation if any.
+func put(t *testing.T, url string, body string) (int, map[string]string) {
+	t.Helper()
+	req, _ := http.NewRequest("PUT", url, strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	h.CheckEqual(t, err, nil)
+	defer resp.Body.Close()
+	var reply gondulapi.Problem
+	h.CheckEqual(t, json.NewDecoder(resp.Body).Decode(&reply), nil)
+	fields := make(map[string]string)
+	for _, f := range reply.Errors {
+		fields[f.Field] = f.Message
+	}
+	return resp.StatusCode, fields
+}
+
+func TestValidate(t *testing.T) {
+	srv := httptest.NewServer(receiver.NewServer().Handler())
+	defer srv.Close()
+
+	code, fields := put(t, srv.URL+"/checked/x", `{"Name": "x", "Status": "OK", "Addr": "192.0.2.1/24", "vlan": 10, "Ports": [{"Name": "ge-0/0/0"}]}`)
+	h.CheckEqual(t, code, 200)
+	h.CheckEqual(t, len(fields), 0)
+
+	code, fields = put(t, srv.URL+"/checked/x", `{"Status": "MEH", "Addr": "2001:db8::1", "vlan": 5000, "Ports": [{"Name": "ge-0/0/0"}, {"Name": "xe-0/0/0/0"}, {}]}`)
+	h.CheckEqual(t, code, 422)
+	h.CheckEqual(t, len(fields), 6)
+	h.CheckEqual(t, fields["Name"], "is required")
+	h.CheckEqual(t, fields["Status"], "must be one of OK, WARN, FAIL")
+	h.CheckEqual(t, fields["Addr"], "must be an IPv4 address")
+	h.CheckEqual(t, fields["vlan"], "must be at most 4094")
+	h.CheckEqual(t, fields["Ports[1].Name"], "must be at most 8 characters long")
+	h.CheckEqual(t, fields["Ports[2].Name"], "is required")
+
+	// The Validator is only asked once the tags are happy.
+	code, fields = put(t, srv.URL+"/checked/x", `{"Name": "x", "Status": "WARN", "vlan": 1, "Low": 2, "High": 1}`)
+	h.CheckEqual(t, code, 422)
+	h.CheckEqual(t, len(fields), 1)
+	h.CheckEqual(t, fields["Low"], "must not be above High")
+}
@@ -96,6 +96,17 @@ func (i *IP) UnmarshalText(b []byte) error {
 	return nil
 }
 
+// JSONSchema describes IP as a string with the non-standard format "ip",
+// since it can be either IPv4 or IPv6, with or without a prefix length.
+// It is used by receiver for the OpenAPI document.
+func (i IP) JSONSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":    "string",
+		"format":  "ip",
+		"example": "192.0.2.1/24",
+	}
+}
+
 // NewIP parses the text string and returns it as an IP data structure
 func NewIP(src string) (IP, error) {
 	i := IP{}